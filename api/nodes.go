@@ -2,9 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
@@ -222,6 +226,129 @@ func (h *Nodes) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 			http.Error(res, "invalid method", http.StatusMethodNotAllowed)
 		}
 
+	case "ack":
+		if req.Method != http.MethodPost {
+			http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		nodes, err := client.GetNodes(h.nc, "all", id, "", false)
+		if err != nil || len(nodes) < 1 {
+			http.Error(res, "node not found", http.StatusNotFound)
+			return
+		}
+
+		if !ackable(nodes[0]) {
+			http.Error(res, "node is not a notify action or alarm",
+				http.StatusBadRequest)
+			return
+		}
+
+		// acknowledge a notification or alarm. The ack point is set
+		// to the user ID so we know who acknowledged it.
+		p := data.Point{
+			Time:   time.Now(),
+			Type:   data.PointTypeAck,
+			Text:   userID,
+			Value:  1,
+			Origin: userID,
+		}
+
+		if p.Text == "" {
+			// origin must be set for the point to reach the
+			// alarm client
+			p.Text = "api"
+			p.Origin = "api"
+		}
+
+		err = client.SendNodePoint(h.nc, id, p, true)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		en := json.NewEncoder(res)
+		err = en.Encode(data.StandardResponse{Success: true, ID: id})
+		if err != nil {
+			http.Error(res, "encoding error", http.StatusMethodNotAllowed)
+		}
+
+	case "alarms":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// list all alarms at or below this node
+		alarms, err := client.GetAlarms(h.nc, id)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if alarms == nil {
+			alarms = []client.Alarm{}
+		}
+
+		en := json.NewEncoder(res)
+		err = en.Encode(alarms)
+		if err != nil {
+			http.Error(res, "encoding error", http.StatusMethodNotAllowed)
+		}
+
+	case "events":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q, err := parseEventQuery(req.URL.Query())
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// list events for this node and all nodes below it
+		events, err := client.GetEvents(h.nc, id, q)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if events == nil {
+			events = []data.Event{}
+		}
+
+		en := json.NewEncoder(res)
+		err = en.Encode(events)
+		if err != nil {
+			http.Error(res, "encoding error", http.StatusMethodNotAllowed)
+		}
+
+	case "history":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q, err := parseHistoryQuery(req.URL.Query())
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results, err := client.GetHistory(h.nc, id, q)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		en := json.NewEncoder(res)
+		err = en.Encode(results)
+		if err != nil {
+			http.Error(res, "encoding error", http.StatusMethodNotAllowed)
+		}
+
 	case "not":
 		switch req.Method {
 		case http.MethodPost:
@@ -258,6 +385,92 @@ func (h *Nodes) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// parseEventQuery parses the level, type, start, stop, and limit query
+// parameters of an events request. Times are RFC3339.
+func parseEventQuery(v url.Values) (data.EventQuery, error) {
+	var q data.EventQuery
+
+	if l := v.Get("level"); l != "" {
+		level, err := strconv.Atoi(l)
+		if err != nil {
+			return q, fmt.Errorf("invalid level: %w", err)
+		}
+		q.Level = data.EventLevel(level)
+	}
+
+	for _, t := range v["type"] {
+		typ, err := strconv.Atoi(t)
+		if err != nil {
+			return q, fmt.Errorf("invalid type: %w", err)
+		}
+		q.Types = append(q.Types, data.EventType(typ))
+	}
+
+	var err error
+
+	if s := v.Get("start"); s != "" {
+		q.Start, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("invalid start: %w", err)
+		}
+	}
+
+	if s := v.Get("stop"); s != "" {
+		q.Stop, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("invalid stop: %w", err)
+		}
+	}
+
+	if l := v.Get("limit"); l != "" {
+		q.Limit, err = strconv.Atoi(l)
+		if err != nil {
+			return q, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+
+	return q, nil
+}
+
+// parseHistoryQuery parses the type, key, start, stop, and window query
+// parameters of a history request. Times are RFC3339 and window is a
+// duration (ex: 1h). If not set, stop is now and start is 24h before stop.
+func parseHistoryQuery(v url.Values) (data.NodeHistoryQuery, error) {
+	q := data.NodeHistoryQuery{
+		Type: v.Get("type"),
+		Key:  v.Get("key"),
+		Stop: time.Now(),
+	}
+
+	var err error
+
+	if s := v.Get("stop"); s != "" {
+		q.Stop, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("invalid stop: %w", err)
+		}
+	}
+
+	q.Start = q.Stop.Add(-24 * time.Hour)
+
+	if s := v.Get("start"); s != "" {
+		q.Start, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("invalid start: %w", err)
+		}
+	}
+
+	if w := v.Get("window"); w != "" {
+		window, err := time.ParseDuration(w)
+		if err != nil {
+			return q, fmt.Errorf("invalid window: %w", err)
+		}
+		q.AggregateWindow = &window
+	}
+
+	return q, nil
+}
+
 // RequestValidator validates an HTTP request.
 type RequestValidator interface {
 	Valid(req *http.Request) (bool, string)
@@ -322,3 +535,16 @@ func (h *Nodes) processPoints(res http.ResponseWriter, req *http.Request, id, us
 		return
 	}
 }
+
+// ackable returns true if a node can be acknowledged with the ack endpoint
+func ackable(n data.NodeEdge) bool {
+	switch n.Type {
+	case data.NodeTypeAlarm:
+		return true
+	case data.NodeTypeAction, data.NodeTypeActionInactive:
+		p, ok := n.Points.Find(data.PointTypeAction, "")
+		return ok && p.Text == data.PointValueNotify
+	default:
+		return false
+	}
+}
@@ -0,0 +1,623 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// alarmJournalMax is the number of journal entries kept for each alarm
+const alarmJournalMax = 50
+
+// Alarm represents an alarm node. Alarms follow the ISA-18.2 alarm states:
+// normal, unackActive, ackActive, and unackCleared.
+type Alarm struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Disabled    bool   `point:"disabled"`
+	// Severity: info, warning, critical
+	Severity string `point:"severity"`
+	// Message is a Go text/template used to generate the alarm text when
+	// the alarm goes active
+	Message string `point:"message"`
+	// optional source point. If NodeID and PointType are set, the alarm is
+	// active while the source point value is non-zero.
+	NodeID    string `point:"nodeID"`
+	PointType string `point:"pointType"`
+	PointKey  string `point:"pointKey"`
+	// Active is the alarm condition. It follows the source point, or can be
+	// set by rule actions.
+	Active bool `point:"active"`
+	// State: normal, unackActive, ackActive, unackCleared
+	State string `point:"state"`
+	Text  string `point:"text"`
+	// Ack is the user that last acknowledged the alarm
+	Ack string `point:"ack"`
+	// Shelve is set to a time in minutes to shelve the alarm. A shelved
+	// alarm is not annunciated until ShelvedUntil (RFC3339) expires.
+	Shelve       float64 `point:"shelve"`
+	ShelvedUntil string  `point:"shelvedUntil"`
+	// Journal is a ring buffer of JSON encoded AlarmJournalEntry
+	Journal      []string `point:"journal"`
+	JournalCount int      `point:"journalCount"`
+}
+
+// Shelved returns true if the alarm is shelved at time t
+func (a Alarm) Shelved(t time.Time) bool {
+	if a.ShelvedUntil == "" {
+		return false
+	}
+
+	until, err := time.Parse(time.RFC3339, a.ShelvedUntil)
+	if err != nil {
+		return false
+	}
+
+	return t.Before(until)
+}
+
+// JournalEntries returns the alarm journal sorted by time
+func (a Alarm) JournalEntries() []AlarmJournalEntry {
+	var ret []AlarmJournalEntry
+	for _, j := range a.Journal {
+		if j == "" {
+			continue
+		}
+
+		var e AlarmJournalEntry
+		err := json.Unmarshal([]byte(j), &e)
+		if err != nil {
+			log.Println("Error decoding alarm journal entry:", err)
+			continue
+		}
+
+		ret = append(ret, e)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Time.Before(ret[j].Time)
+	})
+
+	return ret
+}
+
+// AlarmJournalEntry records an alarm event and the resulting state
+type AlarmJournalEntry struct {
+	Time time.Time `json:"time"`
+	// Event: active, clear, ack, shelve, unshelve, disable, enable
+	Event string `json:"event"`
+	State string `json:"state"`
+	User  string `json:"user,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+// GetAlarms returns all alarms at or below nodeID in the tree. Maps to the
+// `alarms.<id>` NATS API.
+func GetAlarms(nc *nats.Conn, nodeID string) ([]Alarm, error) {
+	msg, err := nc.Request(SubjectAlarms(nodeID), nil, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := data.PbDecodeNodesRequest(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Alarm, len(nodes))
+	for i, n := range nodes {
+		err := data.Decode(data.NodeEdgeChildren{NodeEdge: n}, &ret[i])
+		if err != nil {
+			log.Println("Error decoding alarm:", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// AckAlarm acknowledges an alarm. user is recorded in the ack point and must
+// be set.
+func AckAlarm(nc *nats.Conn, id, user string) error {
+	if user == "" {
+		// points without an origin on the alarm node are ignored by the
+		// alarm client, so the ack would be lost
+		return errors.New("user must be set to acknowledge an alarm")
+	}
+
+	return SendNodePoint(nc, id, data.Point{
+		Time:   time.Now(),
+		Type:   data.PointTypeAck,
+		Text:   user,
+		Value:  1,
+		Origin: user,
+	}, true)
+}
+
+// AlarmClient tracks the state of an alarm node
+type AlarmClient struct {
+	log           *log.Logger
+	nc            *nats.Conn
+	config        Alarm
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	sourcePoints  chan data.Points
+	sourceSub     *nats.Subscription
+}
+
+// NewAlarmClient constructor ...
+func NewAlarmClient(nc *nats.Conn, config Alarm) Client {
+	return &AlarmClient{
+		log:           log.New(os.Stderr, "alarm: ", log.LstdFlags|log.Lmsgprefix),
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		sourcePoints:  make(chan data.Points),
+	}
+}
+
+// Run the main logic for this client and blocks until stopped
+func (ac *AlarmClient) Run() error {
+	shelveTimer := time.NewTimer(time.Hour)
+	shelveTimer.Stop()
+
+	armShelveTimer := func() {
+		if !shelveTimer.Stop() {
+			select {
+			case <-shelveTimer.C:
+			default:
+			}
+		}
+
+		if ac.config.ShelvedUntil == "" {
+			return
+		}
+
+		until, err := time.Parse(time.RFC3339, ac.config.ShelvedUntil)
+		if err != nil {
+			ac.log.Println("Error parsing shelvedUntil:", err)
+			return
+		}
+
+		shelveTimer.Reset(time.Until(until))
+	}
+
+	now := time.Now()
+
+	if ac.config.ShelvedUntil != "" && !ac.config.Shelved(now) {
+		ac.unshelve(now, "")
+	}
+
+	if ac.config.State == "" {
+		ac.setState(now, data.PointValueNormal)
+	}
+
+	suppressed := ac.config.Disabled || ac.config.Shelved(now)
+	if alarmNextState(ac.config.State, ac.config.Active, suppressed) != ac.config.State {
+		// the condition changed while the client was not running
+		ac.transition(now, ac.conditionEvent(), "", "")
+	}
+
+	err := ac.subscribeSource()
+	if err != nil {
+		ac.log.Println("Error subscribing to source point:", err)
+	}
+
+	armShelveTimer()
+
+done:
+	for {
+		select {
+		case <-ac.stop:
+			break done
+		case <-shelveTimer.C:
+			ac.unshelve(time.Now(), "")
+		case pts := <-ac.sourcePoints:
+			for _, p := range pts {
+				if p.Type == ac.config.PointType &&
+					pointKey(p.Key) == pointKey(ac.config.PointKey) {
+					ac.setActive(time.Now(), p.Value != 0, ac.config.NodeID, p.Value)
+				}
+			}
+		case pts := <-ac.newPoints:
+			prev := ac.config
+			err := data.MergePoints(pts.ID, pts.Points, &ac.config)
+			if err != nil {
+				ac.log.Println("error merging new points:", err)
+			}
+
+			now := time.Now()
+			resubscribe := false
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeActive:
+					if ac.config.Active != prev.Active {
+						ac.condition(now, p.Text, p.Value)
+						prev.Active = ac.config.Active
+					}
+				case data.PointTypeAck:
+					user := p.Text
+					if user == "" {
+						user = p.Origin
+					}
+					ac.ack(now, user)
+				case data.PointTypeShelve:
+					if p.Value > 0 {
+						ac.shelve(now, p.Value, p.Origin)
+					} else {
+						ac.unshelve(now, p.Origin)
+					}
+				case data.PointTypeDisabled:
+					if ac.config.Disabled != prev.Disabled {
+						event := "enable"
+						if ac.config.Disabled {
+							event = "disable"
+						}
+						ac.transition(now, event, p.Origin, "")
+						prev.Disabled = ac.config.Disabled
+					}
+				case data.PointTypeNodeID, data.PointTypePointType,
+					data.PointTypePointKey:
+					resubscribe = true
+				}
+			}
+
+			if resubscribe {
+				err := ac.subscribeSource()
+				if err != nil {
+					ac.log.Println("Error subscribing to source point:", err)
+				}
+			}
+
+			armShelveTimer()
+		case pts := <-ac.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &ac.config)
+			if err != nil {
+				ac.log.Println("error merging new points:", err)
+			}
+		}
+	}
+
+	shelveTimer.Stop()
+
+	if ac.sourceSub != nil {
+		return ac.sourceSub.Unsubscribe()
+	}
+
+	return nil
+}
+
+// Stop sends a signal to the Run function to exit
+func (ac *AlarmClient) Stop(_ error) {
+	close(ac.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (ac *AlarmClient) Points(nodeID string, points []data.Point) {
+	ac.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (ac *AlarmClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	ac.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
+
+// subscribeSource subscribes to the alarm source point and sets the alarm
+// condition from the current source point value
+func (ac *AlarmClient) subscribeSource() error {
+	if ac.sourceSub != nil {
+		err := ac.sourceSub.Unsubscribe()
+		if err != nil {
+			ac.log.Println("Error unsubscribing from source point:", err)
+		}
+		ac.sourceSub = nil
+	}
+
+	if ac.config.NodeID == "" || ac.config.PointType == "" {
+		return nil
+	}
+
+	var err error
+	ac.sourceSub, err = ac.nc.Subscribe(SubjectNodePoints(ac.config.NodeID), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			ac.log.Println("Error decoding source points:", err)
+			return
+		}
+
+		select {
+		case ac.sourcePoints <- points:
+		case <-ac.stop:
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	nodes, err := GetNodes(ac.nc, "all", ac.config.NodeID, "", false)
+	if err != nil {
+		return err
+	}
+
+	if len(nodes) > 0 {
+		v, _ := nodes[0].Points.Value(ac.config.PointType, ac.config.PointKey)
+		ac.setActive(time.Now(), v != 0, ac.config.NodeID, v)
+	}
+
+	return nil
+}
+
+// setActive sets the alarm condition from the source point
+func (ac *AlarmClient) setActive(now time.Time, active bool, nodeID string, value float64) {
+	if active == ac.config.Active {
+		return
+	}
+
+	ac.config.Active = active
+
+	err := ac.sendPoint(data.Point{
+		Time:  now,
+		Type:  data.PointTypeActive,
+		Value: data.BoolToFloat(active),
+		Text:  nodeID,
+	})
+	if err != nil {
+		ac.log.Println("Error sending active point:", err)
+	}
+
+	ac.condition(now, nodeID, value)
+}
+
+// condition processes a change in the alarm condition. nodeID is the node
+// that raised the alarm.
+func (ac *AlarmClient) condition(now time.Time, nodeID string, value float64) {
+	if !ac.config.Active {
+		ac.transition(now, "clear", "", "")
+		return
+	}
+
+	text := ac.message(now, nodeID, value)
+	if text != ac.config.Text {
+		err := ac.sendPoint(data.Point{Time: now, Type: data.PointTypeText, Text: text})
+		if err != nil {
+			ac.log.Println("Error sending text point:", err)
+		}
+		ac.config.Text = text
+	}
+
+	ac.transition(now, "active", "", text)
+}
+
+// conditionEvent returns the journal event for the current alarm condition
+func (ac *AlarmClient) conditionEvent() string {
+	if ac.config.Active {
+		return "active"
+	}
+	return "clear"
+}
+
+// transition updates the alarm state after an event and records the event
+// in the journal
+func (ac *AlarmClient) transition(now time.Time, event, user, text string) {
+	suppressed := ac.config.Disabled || ac.config.Shelved(now)
+	state := alarmNextState(ac.config.State, ac.config.Active, suppressed)
+
+	ac.setState(now, state)
+	ac.journal(now, AlarmJournalEntry{
+		Time:  now,
+		Event: event,
+		State: state,
+		User:  user,
+		Text:  text,
+	})
+}
+
+// ack acknowledges the alarm
+func (ac *AlarmClient) ack(now time.Time, user string) {
+	state := alarmAckState(ac.config.State)
+	if state == ac.config.State {
+		// nothing to acknowledge
+		return
+	}
+
+	ac.setState(now, state)
+	ac.journal(now, AlarmJournalEntry{
+		Time:  now,
+		Event: "ack",
+		State: state,
+		User:  user,
+	})
+}
+
+// shelve suppresses the alarm for a time in minutes
+func (ac *AlarmClient) shelve(now time.Time, minutes float64, user string) {
+	until := now.Add(minutesToDuration(minutes)).Format(time.RFC3339Nano)
+	err := ac.sendPoint(data.Point{Time: now, Type: data.PointTypeShelvedUntil, Text: until})
+	if err != nil {
+		ac.log.Println("Error sending shelvedUntil point:", err)
+	}
+
+	ac.config.ShelvedUntil = until
+	ac.transition(now, "shelve", user, "")
+}
+
+// unshelve returns a shelved alarm to service. If the alarm condition is
+// still active, the alarm is annunciated again.
+func (ac *AlarmClient) unshelve(now time.Time, user string) {
+	if ac.config.ShelvedUntil == "" {
+		return
+	}
+
+	err := ac.sendPoint(data.Point{Time: now, Type: data.PointTypeShelvedUntil, Text: ""})
+	if err != nil {
+		ac.log.Println("Error sending shelvedUntil point:", err)
+	}
+
+	ac.config.ShelvedUntil = ""
+	ac.transition(now, "unshelve", user, "")
+}
+
+func (ac *AlarmClient) setState(now time.Time, state string) {
+	if state == ac.config.State {
+		return
+	}
+
+	err := ac.sendPoint(data.Point{Time: now, Type: data.PointTypeState, Text: state})
+	if err != nil {
+		ac.log.Println("Error sending state point:", err)
+	}
+
+	ac.config.State = state
+}
+
+// journal records an entry in the alarm journal. The journal is a ring
+// buffer of alarmJournalMax entries.
+func (ac *AlarmClient) journal(now time.Time, e AlarmJournalEntry) {
+	j, err := json.Marshal(e)
+	if err != nil {
+		ac.log.Println("Error encoding journal entry:", err)
+		return
+	}
+
+	i := ac.config.JournalCount % alarmJournalMax
+	count := ac.config.JournalCount + 1
+
+	pts := data.Points{
+		{Time: now, Type: data.PointTypeJournal, Key: strconv.Itoa(i), Text: string(j)},
+		{Time: now, Type: data.PointTypeJournalCount, Value: float64(count)},
+	}
+
+	err = SendNodePoints(ac.nc, ac.config.ID, pts, false)
+	if err != nil {
+		ac.log.Println("Error sending journal points:", err)
+		return
+	}
+
+	for len(ac.config.Journal) <= i {
+		ac.config.Journal = append(ac.config.Journal, "")
+	}
+	ac.config.Journal[i] = string(j)
+	ac.config.JournalCount = count
+}
+
+func (ac *AlarmClient) sendPoint(p data.Point) error {
+	return SendNodePoint(ac.nc, ac.config.ID, p, false)
+}
+
+// alarmTemplateData is passed to the alarm message template
+type alarmTemplateData struct {
+	Alarm Alarm
+	// Node is the node that raised the alarm (source node or the node that
+	// triggered a rule)
+	Node  data.NodeEdge
+	Value float64
+	Time  time.Time
+}
+
+// message renders the alarm message template. If no message is set, the
+// alarm description is used.
+func (ac *AlarmClient) message(now time.Time, nodeID string, value float64) string {
+	if ac.config.Message == "" {
+		return ac.config.Description
+	}
+
+	d := alarmTemplateData{
+		Alarm: ac.config,
+		Value: value,
+		Time:  now,
+	}
+
+	if nodeID != "" {
+		nodes, err := GetNodes(ac.nc, "all", nodeID, "", false)
+		if err != nil {
+			ac.log.Println("Error getting alarm node:", err)
+		} else if len(nodes) > 0 {
+			d.Node = nodes[0]
+		}
+	}
+
+	t, err := template.New("message").Funcs(actionTemplateFuncs).Parse(ac.config.Message)
+	if err != nil {
+		ac.log.Println("Error parsing alarm message template:", err)
+		return ac.config.Message
+	}
+
+	var b strings.Builder
+	err = t.Execute(&b, d)
+	if err != nil {
+		ac.log.Println("Error executing alarm message template:", err)
+		return ac.config.Message
+	}
+
+	return b.String()
+}
+
+// alarmNextState returns the alarm state for the alarm condition.
+// Suppressed (disabled or shelved) alarms are not annunciated.
+func alarmNextState(state string, active, suppressed bool) string {
+	if suppressed {
+		return data.PointValueNormal
+	}
+
+	switch state {
+	case data.PointValueUnackActive:
+		if !active {
+			return data.PointValueUnackCleared
+		}
+	case data.PointValueAckActive:
+		if !active {
+			return data.PointValueNormal
+		}
+	case data.PointValueUnackCleared:
+		if active {
+			return data.PointValueUnackActive
+		}
+	default:
+		if active {
+			return data.PointValueUnackActive
+		}
+		return data.PointValueNormal
+	}
+
+	return state
+}
+
+// alarmAckState returns the alarm state after it is acknowledged
+func alarmAckState(state string) string {
+	switch state {
+	case data.PointValueUnackActive:
+		return data.PointValueAckActive
+	case data.PointValueUnackCleared:
+		return data.PointValueNormal
+	}
+
+	return state
+}
+
+// pointKey returns the key used for points, where a blank key is "0"
+func pointKey(key string) string {
+	if key == "" {
+		return "0"
+	}
+	return key
+}
+
+func (a Alarm) String() string {
+	return fmt.Sprintf("Alarm: %v, state: %v, active: %v", a.Description, a.State, a.Active)
+}
@@ -0,0 +1,225 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestAlarm(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	v := client.Variable{
+		ID:          "ID-var",
+		Parent:      root.ID,
+		Description: "tank level",
+	}
+
+	alarm := client.Alarm{
+		ID:          "ID-alarm",
+		Parent:      root.ID,
+		Description: "tank high",
+		Severity:    data.PointValueWarning,
+		Message:     `{{desc .Node}} is {{.Value}}`,
+		NodeID:      v.ID,
+		PointType:   data.PointTypeValue,
+	}
+
+	for _, n := range []any{v, alarm} {
+		err = client.SendNodeType(nc, n, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	alarmGet, alarmStop, err := client.NodeWatcher[client.Alarm](nc, alarm.ID, alarm.Parent)
+	if err != nil {
+		t.Fatal("Error setting up alarm watcher: ", err)
+	}
+	defer alarmStop()
+
+	checkState := func(expected, msg string) {
+		t.Helper()
+		start := time.Now()
+		for {
+			if alarmGet().State == expected {
+				return
+			}
+			if time.Since(start) > 2*time.Second {
+				t.Fatalf("%v: expected state %v, got %v", msg, expected, alarmGet().State)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	setValue := func(value float64) {
+		t.Helper()
+		err := client.SendNodePoint(nc, v.ID, data.Point{
+			Type: data.PointTypeValue, Value: value, Origin: "test"}, true)
+		if err != nil {
+			t.Fatal("Error sending value: ", err)
+		}
+	}
+
+	ack := func() {
+		t.Helper()
+		err := client.AckAlarm(nc, alarm.ID, "joe")
+		if err != nil {
+			t.Fatal("Error acking alarm: ", err)
+		}
+	}
+
+	checkState(data.PointValueNormal, "initial")
+
+	if client.AckAlarm(nc, alarm.ID, "") == nil {
+		t.Error("ack without a user should fail")
+	}
+
+	setValue(5)
+	checkState(data.PointValueUnackActive, "source active")
+
+	if alarmGet().Text != "tank level is 5" {
+		t.Error("wrong alarm text: ", alarmGet().Text)
+	}
+
+	ack()
+	checkState(data.PointValueAckActive, "ack while active")
+
+	if alarmGet().Ack != "joe" {
+		t.Error("ack user not recorded: ", alarmGet().Ack)
+	}
+
+	setValue(0)
+	checkState(data.PointValueNormal, "acked alarm cleared")
+
+	setValue(1)
+	checkState(data.PointValueUnackActive, "source active again")
+
+	setValue(0)
+	checkState(data.PointValueUnackCleared, "unacked alarm cleared")
+
+	ack()
+	checkState(data.PointValueNormal, "ack after clear")
+
+	alarms, err := client.GetAlarms(nc, root.ID)
+	if err != nil {
+		t.Fatal("Error getting alarms: ", err)
+	}
+
+	if len(alarms) != 1 || alarms[0].ID != alarm.ID {
+		t.Fatalf("GetAlarms returned wrong alarms: %+v", alarms)
+	}
+
+	journal := alarms[0].JournalEntries()
+	events := []string{"active", "ack", "clear", "active", "clear", "ack"}
+	if len(journal) != len(events) {
+		t.Fatalf("expected %v journal entries, got %+v", len(events), journal)
+	}
+
+	for i, e := range events {
+		if journal[i].Event != e {
+			t.Errorf("journal entry %v, expected %v, got %v", i, e, journal[i].Event)
+		}
+	}
+
+	if journal[1].User != "joe" || journal[1].State != data.PointValueAckActive {
+		t.Errorf("wrong ack journal entry: %+v", journal[1])
+	}
+
+	// shelve the alarm, it should not be annunciated until the shelf expires
+	err = client.SendNodePoint(nc, alarm.ID, data.Point{
+		Type: data.PointTypeShelve, Value: 0.01, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error shelving alarm: ", err)
+	}
+
+	start := time.Now()
+	for !alarmGet().Shelved(time.Now()) {
+		if time.Since(start) > time.Second {
+			t.Fatal("alarm was not shelved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	setValue(1)
+	time.Sleep(100 * time.Millisecond)
+	checkState(data.PointValueNormal, "shelved")
+
+	// shelf expires after 600ms
+	checkState(data.PointValueUnackActive, "shelf expired")
+}
+
+func TestAlarmRuleAction(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	alarm := client.Alarm{
+		ID:          "ID-alarm",
+		Parent:      r.root.ID,
+		Description: "vin alarm",
+	}
+
+	err = client.SendNodeType(r.nc, alarm, "test")
+	if err != nil {
+		t.Fatal("Error sending alarm: ", err)
+	}
+
+	a := client.Action{
+		ID:          "ID-action-alarm",
+		Parent:      r.r.ID,
+		Description: "raise alarm",
+		Action:      data.PointValueAlarm,
+		NodeID:      alarm.ID,
+	}
+
+	err = client.SendNodeType(r.nc, a, "test")
+	if err != nil {
+		t.Fatal("Error sending alarm action: ", err)
+	}
+
+	alarmGet, alarmStop, err := client.NodeWatcher[client.Alarm](r.nc, alarm.ID, alarm.Parent)
+	if err != nil {
+		t.Fatal("Error setting up alarm watcher: ", err)
+	}
+	defer alarmStop()
+
+	time.Sleep(250 * time.Millisecond)
+
+	checkState := func(expected, msg string) {
+		t.Helper()
+		start := time.Now()
+		for {
+			if alarmGet().State == expected {
+				return
+			}
+			if time.Since(start) > 2*time.Second {
+				t.Fatalf("%v: expected state %v, got %v", msg, expected, alarmGet().State)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "rule active", "0")
+	checkState(data.PointValueUnackActive, "rule raised alarm")
+
+	if alarmGet().Text != alarm.Description {
+		t.Error("alarm text should default to description: ", alarmGet().Text)
+	}
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(0, "rule inactive", "0")
+	checkState(data.PointValueUnackCleared, "rule cleared alarm")
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// point batch encodings. The first byte of a batch message is the encoding.
+const (
+	batchEncodingPb      = 0
+	batchEncodingDeflate = 1
+)
+
+// batchMaxMsgSize is the max size of a single encoded point message assumed
+// when limiting the decompressed size of a batch.
+const batchMaxMsgSize = 16 * 1024
+
+// batchMaxSize is the max decompressed size of a point batch. Batches come
+// from remote instances, so this keeps a small compressed payload from
+// expanding without bound.
+const batchMaxSize = syncBatchMax * batchMaxMsgSize
+
+// EncodePointBatch encodes a list of point messages to be sent on the
+// pointBatch subject. Nodes with a Parent contain edge points in EdgePoints,
+// otherwise node points in Points. If compress is set, the batch is
+// compressed with deflate.
+func EncodePointBatch(msgs data.Nodes, compress bool) ([]byte, error) {
+	pb, err := msgs.ToPb()
+	if err != nil {
+		return nil, err
+	}
+
+	if !compress {
+		return append([]byte{batchEncodingPb}, pb...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(batchEncodingDeflate)
+
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(pb)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodePointBatch decodes a batch of point messages
+func DecodePointBatch(b []byte) (data.Nodes, error) {
+	if len(b) < 1 {
+		return nil, errors.New("empty point batch")
+	}
+
+	pb := b[1:]
+
+	switch b[0] {
+	case batchEncodingPb:
+	case batchEncodingDeflate:
+		r := flate.NewReader(bytes.NewReader(pb))
+		var err error
+		pb, err = io.ReadAll(io.LimitReader(r, batchMaxSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing point batch: %w", err)
+		}
+		if len(pb) > batchMaxSize {
+			return nil, fmt.Errorf("point batch exceeds %v bytes decompressed",
+				batchMaxSize)
+		}
+	default:
+		return nil, fmt.Errorf("unknown point batch encoding: %v", b[0])
+	}
+
+	return data.PbDecodeNodes(pb)
+}
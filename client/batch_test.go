@@ -0,0 +1,53 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestPointBatch(t *testing.T) {
+	msgs := data.Nodes{
+		{ID: "a", Points: data.Points{{Type: data.PointTypeValue, Value: 1}}},
+		{ID: "b", Parent: "a", EdgePoints: data.Points{{Type: data.PointTypeTombstone}}},
+	}
+
+	for _, compress := range []bool{false, true} {
+		b, err := EncodePointBatch(msgs, compress)
+		if err != nil {
+			t.Fatal("Error encoding batch: ", err)
+		}
+
+		res, err := DecodePointBatch(b)
+		if err != nil {
+			t.Fatal("Error decoding batch: ", err)
+		}
+
+		if len(res) != 2 || res[0].Points[0].Value != 1 || res[1].Parent != "a" ||
+			res[1].EdgePoints[0].Type != data.PointTypeTombstone {
+			t.Errorf("batch did not round trip, compress: %v, got: %+v", compress, res)
+		}
+	}
+}
+
+func TestPointBatchTooLarge(t *testing.T) {
+	// a valid batch that compresses to a small payload but expands past
+	// the limit
+	msgs := data.Nodes{{ID: "a", Points: data.Points{{Type: data.PointTypeDescription,
+		Text: strings.Repeat("a", batchMaxSize)}}}}
+
+	b, err := EncodePointBatch(msgs, true)
+	if err != nil {
+		t.Fatal("Error encoding batch: ", err)
+	}
+
+	if len(b) > batchMaxSize/100 {
+		t.Fatal("expected batch to compress well, got: ", len(b))
+	}
+
+	_, err = DecodePointBatch(b)
+	if err == nil {
+		t.Error("expected error for oversized batch")
+	}
+}
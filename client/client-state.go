@@ -3,6 +3,7 @@ package client
 import (
 	"fmt"
 	"log"
+	"reflect"
 	"sync"
 	"time"
 
@@ -25,21 +26,15 @@ type clientState[T any] struct {
 func newClientState[T any](nc *nats.Conn, construct func(*nats.Conn, T) Client,
 	n data.NodeEdge) (*clientState[T], error) {
 
-	c, err := GetNodes(nc, n.ID, "all", "", false)
+	var config T
+
+	ncc, err := getChildren(nc, n.ID, reflect.TypeOf(config))
 	if err != nil {
 		return nil, fmt.Errorf("Error getting children: %v", err)
 	}
 
-	ncc := make([]data.NodeEdgeChildren, len(c))
-
-	for i, nci := range c {
-		ncc[i] = data.NodeEdgeChildren{NodeEdge: nci, Children: nil}
-	}
-
 	nec := data.NodeEdgeChildren{NodeEdge: n, Children: ncc}
 
-	var config T
-
 	err = data.Decode(nec, &config)
 	if err != nil {
 		return nil, fmt.Errorf("Error decoding node: %w", err)
@@ -58,6 +53,62 @@ func newClientState[T any](nc *nats.Conn, construct func(*nats.Conn, T) Client,
 	return ret, nil
 }
 
+// getChildren returns the children of a node. If the child node type maps to
+// a struct that has child fields itself (for instance rule condition
+// groups), then the children of that node are fetched as well.
+func getChildren(nc *nats.Conn, id string, t reflect.Type) ([]data.NodeEdgeChildren, error) {
+	c, err := GetNodes(nc, id, "all", "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]data.NodeEdgeChildren, len(c))
+
+	for i, nci := range c {
+		ret[i] = data.NodeEdgeChildren{NodeEdge: nci, Children: nil}
+
+		ct := childType(t, nci.Type)
+		if ct == nil {
+			continue
+		}
+
+		ret[i].Children, err = getChildren(nc, nci.ID, ct)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// childType returns the struct type used for child nodes of nodeType if that
+// struct in turn has child fields, otherwise nil.
+func childType(t reflect.Type, nodeType string) reflect.Type {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("child") != nodeType || sf.Type.Kind() != reflect.Slice {
+			continue
+		}
+
+		et := sf.Type.Elem()
+		if et.Kind() != reflect.Struct {
+			return nil
+		}
+
+		for j := 0; j < et.NumField(); j++ {
+			if et.Field(j).Tag.Get("child") != "" {
+				return et
+			}
+		}
+	}
+
+	return nil
+}
+
 func (cs *clientState[T]) run() (err error) {
 
 	chClientStopped := make(chan struct{})
@@ -36,6 +36,12 @@ func DefaultClients(nc *nats.Conn) (*RunGroup, error) {
 	rc := NewManager(nc, NewRuleClient, nil)
 	g.Add(rc)
 
+	ms := NewManager(nc, NewMsgServiceClient, nil)
+	g.Add(ms)
+
+	alarm := NewManager(nc, NewAlarmClient, []string{data.NodeTypeDevice})
+	g.Add(alarm)
+
 	db := NewManager(nc, NewDbClient, nil)
 	g.Add(db)
 
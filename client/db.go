@@ -3,12 +3,16 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/nats-io/nats.go"
 	"github.com/simpleiot/simpleiot/data"
 )
@@ -16,6 +20,25 @@ import (
 // InfluxMeasurement is the Influx measurement to which all points are written
 const InfluxMeasurement = "points"
 
+// InfluxMeasurementEdge is the Influx measurement to which edge points are
+// written. Edge points record node creation (nodeType point), deletion and
+// moves (tombstone point), and other edge changes like user roles.
+const InfluxMeasurementEdge = "edgePoints"
+
+const (
+	// dbQueueSize is the default max number of points queued while
+	// Influx is unreachable
+	dbQueueSize = 100000
+	// dbWriteBatch is the max number of points written in one request
+	dbWriteBatch = 5000
+	// dbWritePeriod is how often queued points are written
+	dbWritePeriod  = time.Second
+	dbWriteTimeout = 10 * time.Second
+	dbBackoffMax   = time.Minute
+	// dbStatsPeriod is how often queue stats are reported
+	dbStatsPeriod = 10 * time.Second
+)
+
 // Db represents the configuration for a SIOT DB client
 type Db struct {
 	ID            string   `node:"id"`
@@ -26,6 +49,9 @@ type Db struct {
 	Bucket        string   `point:"bucket"`
 	AuthToken     string   `point:"authToken"`
 	TagPointTypes []string `point:"tagPointType"`
+	QueueSize     int      `point:"queueSize"`
+	QueueDepth    int      `point:"queueDepth"`
+	QueueDropped  int      `point:"queueDropped"`
 }
 
 // DbClient is a SIOT database client
@@ -37,11 +63,14 @@ type DbClient struct {
 	newEdgePoints chan NewPoints
 	newDbPoints   chan NewPoints
 	upSub         *nats.Subscription
+	upSubEdge     *nats.Subscription
 	upSubHr       *nats.Subscription
 	historySub    *nats.Subscription
 	nodeCache     nodeCache
 	client        influxdb2.Client
-	writeAPI      api.WriteAPI
+	// queue buffers points in Influx line protocol until they are written
+	queue   *spool
+	chStats chan struct{}
 }
 
 // NewDbClient ...
@@ -54,6 +83,7 @@ func NewDbClient(nc *nats.Conn, config Db) Client {
 		newEdgePoints: make(chan NewPoints),
 		newDbPoints:   make(chan NewPoints),
 		nodeCache:     newNodeCache(config.TagPointTypes),
+		chStats:       make(chan struct{}, 1),
 	}
 }
 
@@ -62,7 +92,10 @@ func (dbc *DbClient) Run() error {
 	log.Println("Starting db client:", dbc.config.Description)
 	var err error
 
-	// FIXME, we probably want to store edge points too ...
+	dbc.queue, err = newSpool(spoolPath("db-"+dbc.config.ID), dbc.queueSize())
+	if err != nil {
+		return fmt.Errorf("opening db queue: %w", err)
+	}
 
 	subject := fmt.Sprintf("up.%v.*", dbc.config.Parent)
 	dbc.upSub, err = dbc.nc.Subscribe(subject, func(msg *nats.Msg) {
@@ -86,6 +119,29 @@ func (dbc *DbClient) Run() error {
 		return fmt.Errorf("subscribing to %v: %w", subject, err)
 	}
 
+	// edge points record when nodes are created, moved, or deleted
+	subjectEdge := fmt.Sprintf("up.%v.*.*", dbc.config.Parent)
+	dbc.upSubEdge, err = dbc.nc.Subscribe(subjectEdge, func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("Error decoding points in db upSubEdge:", err)
+			return
+		}
+
+		// find node and parent ID for points
+		chunks := strings.Split(msg.Subject, ".")
+		if len(chunks) != 4 {
+			log.Println("db client up edge sub, malformed subject:", msg.Subject)
+			return
+		}
+
+		dbc.newDbPoints <- NewPoints{chunks[2], chunks[3], points}
+	})
+
+	if err != nil {
+		return fmt.Errorf("subscribing to %v: %w", subjectEdge, err)
+	}
+
 	subjectHR := fmt.Sprintf("phrup.%v.*", dbc.config.Parent)
 	dbc.upSubHr, err = dbc.nc.Subscribe(subjectHR, func(msg *nats.Msg) {
 		// find node ID for points
@@ -117,7 +173,10 @@ func (dbc *DbClient) Run() error {
 					"value": pt.Value,
 				},
 				pt.Time)
-			dbc.writeAPI.WritePoint(p)
+			err := dbc.queue.Push([]byte(write.PointToLineProtocol(p, time.Nanosecond)))
+			if err != nil {
+				log.Println("DB: error queuing HR point:", err)
+			}
 		})
 
 		if err != nil {
@@ -129,7 +188,7 @@ func (dbc *DbClient) Run() error {
 		return fmt.Errorf("subscribing to %v: %w", subjectHR, err)
 	}
 
-	subjectHistory := fmt.Sprintf("history.%v", dbc.config.ID)
+	subjectHistory := SubjectHistory(dbc.config.ID)
 	dbc.historySub, err = dbc.nc.Subscribe(subjectHistory, func(msg *nats.Msg) {
 		query := new(data.HistoryQuery)
 		results := new(data.HistoryResults)
@@ -187,34 +246,46 @@ func (dbc *DbClient) Run() error {
 		return fmt.Errorf("subscribing to %v: %w", subjectHistory, err)
 	}
 
+	var writerCancel context.CancelFunc
+	writerDone := make(chan struct{})
+
 	setupAPI := func() {
 		log.Println("Setting up Influx API")
 		// you can set things like retries, batching, precision, etc in client options.
 		dbc.client = influxdb2.NewClientWithOptions(dbc.config.URI,
 			dbc.config.AuthToken, influxdb2.DefaultOptions())
-		dbc.writeAPI = dbc.client.WriteAPI(dbc.config.Org, dbc.config.Bucket)
 
-		influxErrors := dbc.writeAPI.Errors()
-
-		go func() {
-			for err := range influxErrors {
-				if err != nil {
-					log.Println("Influx write error:", err)
-				}
+		var ctx context.Context
+		ctx, writerCancel = context.WithCancel(context.Background())
+		writerDone = make(chan struct{})
+		go dbc.writer(ctx, dbc.client.WriteAPIBlocking(dbc.config.Org,
+			dbc.config.Bucket), writerDone)
+	}
 
-			}
-			log.Println("Influxdb write api closed")
-		}()
+	closeAPI := func() {
+		writerCancel()
+		<-writerDone
+		dbc.client.Close()
 	}
 
 	setupAPI()
 
+	stats := newSpoolStats(dbc.nc, dbc.config.ID, dbc.config.QueueDropped)
+	stats.report(dbc.queue)
+
+	statsTicker := time.NewTicker(dbStatsPeriod)
+	defer statsTicker.Stop()
+
 done:
 	for {
 		select {
 		case <-dbc.stop:
 			log.Println("Stopping db client:", dbc.config.Description)
 			break done
+		case <-statsTicker.C:
+			stats.report(dbc.queue)
+		case <-dbc.chStats:
+			stats.report(dbc.queue)
 		case pts := <-dbc.newPoints:
 			err := data.MergePoints(pts.ID, pts.Points, &dbc.config)
 			if err != nil {
@@ -228,10 +299,14 @@ done:
 					data.PointTypeBucket,
 					data.PointTypeAuthToken:
 					// we need to restart the influx write API
-					dbc.client.Close()
+					closeAPI()
 					setupAPI()
 				case data.PointTypeTagPointType:
 					dbc.nodeCache = newNodeCache(dbc.config.TagPointTypes)
+				case data.PointTypeQueueSize:
+					dbc.queue.SetMax(dbc.queueSize())
+				case data.PointTypeQueueDropped:
+					stats.setDropped(dbc.config.QueueDropped, dbc.queue)
 				}
 			}
 
@@ -241,36 +316,168 @@ done:
 				log.Println("error merging new points:", err)
 			}
 		case pts := <-dbc.newDbPoints:
+			measurement := InfluxMeasurement
+			cachePts := pts
+
+			if pts.Parent != "" {
+				// edge points are not node points, so only
+				// make sure the node is in the cache
+				measurement = InfluxMeasurementEdge
+				cachePts = NewPoints{ID: pts.ID}
+			}
+
 			// Update nodeCache if needed
-			err := dbc.nodeCache.Update(dbc.nc, pts)
+			err := dbc.nodeCache.Update(dbc.nc, cachePts)
 			if err != nil {
 				log.Printf("error updating cache: %v", err)
 			}
-			// Add points to InfluxDB
-			for _, point := range pts.Points {
+			// Add points to the write queue
+			lines := make([][]byte, len(pts.Points))
+			for i, point := range pts.Points {
 				tags := map[string]string{
 					"type": point.Type,
 					"key":  point.Key,
 				}
+				fields := map[string]interface{}{
+					"value": point.Value,
+					"text":  point.Text,
+				}
+
 				dbc.nodeCache.CopyTags(pts.ID, tags)
-				p := influxdb2.NewPoint(InfluxMeasurement,
-					tags,
-					map[string]interface{}{
-						"value": point.Value,
-						"text":  point.Text,
-					},
-					point.Time)
-				dbc.writeAPI.WritePoint(p)
+
+				if pts.Parent != "" {
+					// deleted nodes may not be in the cache
+					tags["node.id"] = pts.ID
+					tags["node.parent"] = pts.Parent
+					fields["tombstone"] = point.Tombstone
+				}
+
+				p := influxdb2.NewPoint(measurement, tags, fields, point.Time)
+				lines[i] = []byte(write.PointToLineProtocol(p, time.Nanosecond))
+			}
+
+			err = dbc.queue.Push(lines...)
+			if err != nil {
+				log.Println("Error queuing db points:", err)
 			}
 		}
 	}
 
 	// clean up
 	_ = dbc.upSub.Unsubscribe()
+	_ = dbc.upSubEdge.Unsubscribe()
 	_ = dbc.upSubHr.Unsubscribe()
 	_ = dbc.historySub.Unsubscribe()
-	dbc.client.Close()
-	return nil
+	closeAPI()
+	return dbc.queue.Close()
+}
+
+func (dbc *DbClient) queueSize() int {
+	if dbc.config.QueueSize > 0 {
+		return dbc.config.QueueSize
+	}
+	return dbQueueSize
+}
+
+// writer writes queued points to Influx in order until the context is
+// canceled. If Influx is unreachable, the points stay in the queue and
+// writes are retried with a backoff.
+func (dbc *DbClient) writer(ctx context.Context, w api.WriteAPIBlocking, done chan struct{}) {
+	defer close(done)
+
+	attempts := 0
+	timer := time.NewTimer(dbWritePeriod)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		delay := dbWritePeriod
+		wasFailing := attempts > 0
+
+		for ctx.Err() == nil {
+			records, seq, err := dbc.queue.Peek(dbWriteBatch)
+			if err != nil {
+				log.Println("Error reading db queue:", err)
+				break
+			}
+
+			if len(records) <= 0 {
+				break
+			}
+
+			lines := make([]string, len(records))
+			for i, r := range records {
+				lines[i] = string(r)
+			}
+
+			wCtx, cancel := context.WithTimeout(ctx, dbWriteTimeout)
+			err = w.WriteRecord(wCtx, lines...)
+			cancel()
+
+			if err != nil {
+				if dbDataRejected(err) {
+					log.Println("Influx rejected points, dropping:", err)
+					err := dbc.queue.Drop(seq, len(records))
+					if err != nil {
+						log.Println("Error dropping db queue points:", err)
+						break
+					}
+					continue
+				}
+
+				if attempts == 0 {
+					log.Println("Influx write error, queuing points:", err)
+				}
+				attempts++
+				delay = ExpBackoff(attempts, dbBackoffMax)
+				break
+			}
+
+			if attempts > 0 {
+				log.Println("Influx write recovered")
+				attempts = 0
+			}
+
+			err = dbc.queue.Pop(seq, len(records))
+			if err != nil {
+				log.Println("Error removing points from db queue:", err)
+				break
+			}
+		}
+
+		if wasFailing != (attempts > 0) {
+			// report queue stats when Influx goes offline or the
+			// queue has been written after an outage
+			select {
+			case dbc.chStats <- struct{}{}:
+			default:
+			}
+		}
+
+		timer.Reset(delay)
+	}
+}
+
+// dbDataRejected returns true if Influx rejected the points themselves, so
+// retrying the write will not help. Other errors such as an expired token
+// (401/403) or a missing bucket (404) are retried so the queue is not lost.
+func dbDataRejected(err error) bool {
+	var httpErr *http.Error
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+
+	switch httpErr.StatusCode {
+	case 400, 413, 422:
+		return true
+	}
+
+	return false
 }
 
 // Stop sends a signal to the Run function to exit
@@ -3,8 +3,15 @@ package client_test
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -122,3 +129,241 @@ func TestDb(t *testing.T) {
 		t.Fatal("Point value not correct")
 	}
 }
+
+// fakeInflux is an Influx write endpoint that can be taken offline. When
+// offline, writes fail with status, or 503 if status is not set.
+type fakeInflux struct {
+	lock   sync.Mutex
+	online bool
+	status int
+	lines  []string
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.online {
+		if f.status != 0 {
+			w.WriteHeader(f.status)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	for _, l := range strings.Split(string(body), "\n") {
+		if l != "" {
+			f.lines = append(f.lines, l)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeInflux) setOnline(online bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.online = online
+}
+
+// values returns the value field of value points received
+func (f *fakeInflux) values() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var ret []string
+	for _, l := range f.lines {
+		if !strings.Contains(l, "type=value") {
+			continue
+		}
+		for _, field := range strings.Split(strings.Fields(l)[1], ",") {
+			if strings.HasPrefix(field, "value=") {
+				ret = append(ret, strings.TrimPrefix(field, "value="))
+			}
+		}
+	}
+	return ret
+}
+
+func TestDbQueue(t *testing.T) {
+	t.Run("unavailable", func(t *testing.T) {
+		testDbQueue(t, http.StatusServiceUnavailable)
+	})
+
+	// an expired token or missing bucket must not drop the queue
+	t.Run("unauthorized", func(t *testing.T) {
+		testDbQueue(t, http.StatusUnauthorized)
+	})
+}
+
+// testDbQueue checks that points are queued while Influx fails writes with
+// status and are replayed in order once Influx is back online.
+func testDbQueue(t *testing.T, status int) {
+	t.Setenv("SIOT_DATA", t.TempDir())
+
+	influx := &fakeInflux{status: status}
+	influxServer := httptest.NewServer(influx)
+	defer influxServer.Close()
+
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	dbConfig := client.Db{
+		ID:          "ID-db",
+		Parent:      root.ID,
+		Description: "influxdb",
+		URI:         influxServer.URL,
+		Org:         "siot-test",
+		Bucket:      "test",
+	}
+
+	err = client.SendNodeType(nc, dbConfig, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	dbGet, dbStop, err := client.NodeWatcher[client.Db](nc, dbConfig.ID, dbConfig.Parent)
+	if err != nil {
+		t.Fatal("Error setting up db watcher: ", err)
+	}
+	defer dbStop()
+
+	v := client.Variable{ID: "ID-var", Parent: root.ID, Description: "var"}
+	err = client.SendNodeType(nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending variable: ", err)
+	}
+
+	// wait for client to start
+	time.Sleep(time.Millisecond * 100)
+
+	expected := []string{"1", "2", "3", "4", "5"}
+
+	for _, val := range expected {
+		value, _ := strconv.ParseFloat(val, 64)
+		err := client.SendNodePoint(nc, v.ID, data.Point{Type: data.PointTypeValue,
+			Value: value, Origin: "test"}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	waitFor := func(msg string, f func() bool) {
+		t.Helper()
+		start := time.Now()
+		for !f() {
+			if time.Since(start) > 15*time.Second {
+				t.Fatal("timeout waiting for ", msg)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	// queue stats are reported after the first failed write, so the
+	// points must still be queued at that point
+	waitFor("queue depth", func() bool { return dbGet().QueueDepth >= len(expected) })
+
+	if len(influx.values()) != 0 {
+		t.Fatal("offline influx should not receive points")
+	}
+
+	influx.setOnline(true)
+
+	waitFor("queued points", func() bool { return len(influx.values()) >= len(expected) })
+
+	if vals := influx.values(); !slices.Equal(vals, expected) {
+		t.Fatalf("points not replayed in order, expected %v, got %v", expected, vals)
+	}
+
+	waitFor("empty queue", func() bool { return dbGet().QueueDepth == 0 })
+
+	if dbGet().QueueDropped != 0 {
+		t.Error("expected no dropped points, got: ", dbGet().QueueDropped)
+	}
+}
+
+func TestDbEdgePoints(t *testing.T) {
+	t.Setenv("SIOT_DATA", t.TempDir())
+
+	influx := &fakeInflux{online: true}
+	influxServer := httptest.NewServer(influx)
+	defer influxServer.Close()
+
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	dbConfig := client.Db{
+		ID:     "ID-db",
+		Parent: root.ID,
+		URI:    influxServer.URL,
+	}
+
+	err = client.SendNodeType(nc, dbConfig, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	// wait for client to start
+	time.Sleep(time.Millisecond * 100)
+
+	v := client.Variable{ID: "ID-var", Parent: root.ID, Description: "var"}
+	err = client.SendNodeType(nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending variable: ", err)
+	}
+
+	err = client.DeleteNode(nc, v.ID, v.Parent, "test")
+	if err != nil {
+		t.Fatal("Error deleting variable: ", err)
+	}
+
+	// find the edge point lines for the variable
+	findLine := func(contains ...string) bool {
+		influx.lock.Lock()
+		defer influx.lock.Unlock()
+
+	next:
+		for _, l := range influx.lines {
+			if !strings.HasPrefix(l, client.InfluxMeasurementEdge+",") {
+				continue
+			}
+			for _, c := range contains {
+				if !strings.Contains(l, c) {
+					continue next
+				}
+			}
+			return true
+		}
+		return false
+	}
+
+	checks := []struct {
+		desc     string
+		contains []string
+	}{
+		{"node created", []string{"node.id=ID-var", "node.parent=" + root.ID,
+			"node.type=variable", "type=nodeType", `text="variable"`}},
+		{"node deleted", []string{"node.id=ID-var", "node.description=var",
+			"type=tombstone", "value=1"}},
+	}
+
+	for _, c := range checks {
+		start := time.Now()
+		for !findLine(c.contains...) {
+			if time.Since(start) > 5*time.Second {
+				influx.lock.Lock()
+				t.Fatalf("%v: edge point not written, lines: %q", c.desc, influx.lines)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
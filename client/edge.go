@@ -17,6 +17,9 @@ type EdgeOptions struct {
 	Disconnected func()
 	Reconnected  func()
 	Closed       func()
+	// ConnectFailed is called with the number of attempts each time a
+	// connection or reconnection attempt fails
+	ConnectFailed func(attempts int)
 }
 
 // EdgeConnect is a function that attempts connections for edge devices with appropriate
@@ -60,6 +63,9 @@ func EdgeConnect(eo EdgeOptions) (*nats.Conn, error) {
 		})(o)
 
 		_ = nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			if eo.ConnectFailed != nil {
+				eo.ConnectFailed(attempts)
+			}
 			delay := ExpBackoff(attempts, 6*time.Minute)
 			log.Printf("NATS reconnect attempts: %v, delay: %v", attempts, delay)
 			return delay
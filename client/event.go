@@ -0,0 +1,54 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// SendEvent publishes an event for a node. The event time is set to the
+// current time if not set. Events are stored by the store and can be
+// queried with [GetEvents].
+func SendEvent(nc *nats.Conn, nodeID string, e data.Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	e.NodeID = nodeID
+
+	d, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return nc.Publish(SubjectEvent(nodeID), d)
+}
+
+// GetEvents returns events for a node and all nodes below it. Maps to the
+// `events.<id>` NATS API.
+func GetEvents(nc *nats.Conn, nodeID string, q data.EventQuery) ([]data.Event, error) {
+	d, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := nc.Request(SubjectEvents(nodeID), d, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	var results data.EventResults
+	err = json.Unmarshal(msg.Data, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	if results.ErrorMessage != "" {
+		return nil, errors.New(results.ErrorMessage)
+	}
+
+	return results.Events, nil
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// GetHistory returns the history of a node point from the configured history
+// backend. Maps to the `nodeHistory.<id>` NATS API.
+func GetHistory(nc *nats.Conn, nodeID string, q data.NodeHistoryQuery) (data.HistoryResults, error) {
+	var results data.HistoryResults
+
+	d, err := json.Marshal(q)
+	if err != nil {
+		return results, err
+	}
+
+	msg, err := nc.Request(SubjectNodeHistory(nodeID), d, time.Second*20)
+	if err != nil {
+		return results, err
+	}
+
+	err = json.Unmarshal(msg.Data, &results)
+	if err != nil {
+		return results, err
+	}
+
+	if results.ErrorMessage != "" {
+		return results, errors.New(results.ErrorMessage)
+	}
+
+	return results, nil
+}
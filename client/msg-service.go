@@ -0,0 +1,428 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/msg"
+)
+
+// MsgService represents a message service (Twilio, SMTP, etc) that is used
+// to deliver notifications to users.
+type MsgService struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Disabled    bool   `point:"disabled"`
+	// Service: twilio, smtp, webhook
+	Service string `point:"service"`
+	// From is the phone number or email address messages are sent from
+	From string `point:"from"`
+	// the following are used for Twilio
+	SID       string `point:"sid"`
+	AuthToken string `point:"authToken"`
+	// URI can be used to override the Twilio API URL, and is the URL
+	// webhook messages are sent to
+	URI string `point:"uri"`
+	// the following are used for SMTP. Security: none, starttls, tls
+	Host     string `point:"host"`
+	Port     int    `point:"port"`
+	Security string `point:"security"`
+	Username string `point:"username"`
+	Password string `point:"password"`
+}
+
+// MsgServiceClient delivers notifications (published to node.<id>.not) to
+// users. Users are found by walking up the tree from the node that
+// generated the notification. Each user is messaged through the nearest
+// message service of each type found walking up from the user.
+type MsgServiceClient struct {
+	log           *log.Logger
+	nc            *nats.Conn
+	config        MsgService
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	notifications chan notificationMsg
+	notSub        *nats.Subscription
+}
+
+type notificationMsg struct {
+	nodeID string
+	not    data.Notification
+}
+
+// NewMsgServiceClient constructor ...
+func NewMsgServiceClient(nc *nats.Conn, config MsgService) Client {
+	return &MsgServiceClient{
+		log:           log.New(os.Stderr, "msgService: ", log.LstdFlags|log.Lmsgprefix),
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		notifications: make(chan notificationMsg, 10),
+	}
+}
+
+// Run the main logic for this client and blocks until stopped
+func (msc *MsgServiceClient) Run() error {
+	var err error
+	msc.notSub, err = msc.nc.Subscribe("node.*.not", func(natsMsg *nats.Msg) {
+		chunks := strings.Split(natsMsg.Subject, ".")
+		if len(chunks) < 3 {
+			msc.log.Println("Error in notification subject:", natsMsg.Subject)
+			return
+		}
+
+		not, err := data.PbDecodeNotification(natsMsg.Data)
+		if err != nil {
+			msc.log.Println("Error decoding notification:", err)
+			return
+		}
+
+		select {
+		case msc.notifications <- notificationMsg{nodeID: chunks[1], not: not}:
+		case <-msc.stop:
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error subscribing to notifications: %w", err)
+	}
+
+done:
+	for {
+		select {
+		case <-msc.stop:
+			break done
+		case n := <-msc.notifications:
+			if msc.config.Disabled {
+				break
+			}
+
+			// deliver in the background as sending messages
+			// can take a while
+			go msc.notify(msc.config, n)
+		case pts := <-msc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &msc.config)
+			if err != nil {
+				msc.log.Println("error merging new points:", err)
+			}
+		case pts := <-msc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &msc.config)
+			if err != nil {
+				msc.log.Println("error merging new points:", err)
+			}
+		}
+	}
+
+	return msc.notSub.Unsubscribe()
+}
+
+// Stop sends a signal to the Run function to exit
+func (msc *MsgServiceClient) Stop(_ error) {
+	close(msc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (msc *MsgServiceClient) Points(nodeID string, points []data.Point) {
+	msc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (msc *MsgServiceClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	msc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
+
+// notify delivers a notification to all users this service is responsible for
+func (msc *MsgServiceClient) notify(config MsgService, n notificationMsg) {
+	users, err := notificationUsers(msc.nc, n.nodeID, n.not.Parent)
+	if err != nil {
+		msc.log.Println("Error finding notification users:", err)
+		return
+	}
+
+	text := n.not.Message
+	if n.not.Subject != "" {
+		text = n.not.Subject + ": " + n.not.Message
+	}
+
+	for _, u := range users {
+		svcs, err := userMsgServices(msc.nc, u.Parent, config.Service)
+		if err != nil {
+			msc.log.Println("Error finding user message services:", err)
+			continue
+		}
+
+		// the first service found of each type delivers the message
+		if len(svcs) < 1 || svcs[0].ID != config.ID {
+			continue
+		}
+
+		allowed, err := u.notificationAllowed(msgServiceChannel(config.Service),
+			n.not.Severity, time.Now())
+		if err != nil {
+			msc.log.Printf("Error checking notification preferences for %v %v: %v\n",
+				u.FirstName, u.LastName, err)
+		} else if !allowed {
+			continue
+		}
+
+		var errSend error
+		switch config.Service {
+		case data.PointValueTwilio:
+			if u.Phone == "" {
+				continue
+			}
+			twilio := msg.NewTwilio(config.SID, config.AuthToken, config.From)
+			if config.URI != "" {
+				twilio.SetBaseURL(config.URI)
+			}
+			errSend = twilio.SendSMS(u.Phone, text)
+		case data.PointValueSMTP:
+			if u.Email == "" {
+				continue
+			}
+			subject := n.not.Subject
+			if subject == "" {
+				subject = "Simple IoT notification"
+			}
+			smtp := msg.NewSMTP(config.Host, config.Port, config.Security,
+				config.Username, config.Password, config.From)
+			errSend = smtp.SendEmail(u.Email, subject, n.not.Message)
+		case data.PointValueWebhook:
+			webhook := msg.NewWebhook(config.URI)
+			errSend = webhook.Send(webhookMsg{
+				UserID:    u.ID,
+				FirstName: u.FirstName,
+				LastName:  u.LastName,
+				Email:     u.Email,
+				Phone:     u.Phone,
+				Subject:   n.not.Subject,
+				Message:   n.not.Message,
+				Severity:  n.not.Severity,
+			})
+		default:
+			errSend = fmt.Errorf("unsupported message service: %v", config.Service)
+		}
+
+		msc.recordDelivery(config, u, text, errSend)
+	}
+}
+
+// webhookMsg is the JSON body sent by webhook message services
+type webhookMsg struct {
+	UserID    string `json:"userId"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	Subject   string `json:"subject"`
+	Message   string `json:"message"`
+	Severity  string `json:"severity"`
+}
+
+// msgServiceChannel returns the user notification channel for a service
+func msgServiceChannel(service string) string {
+	switch service {
+	case data.PointValueTwilio:
+		return data.PointValueSMS
+	case data.PointValueSMTP:
+		return data.PointValueEmail
+	default:
+		return service
+	}
+}
+
+// recordDelivery writes msgAll/msgUser points for a delivery attempt. The
+// point value is 1 if the message was delivered, 0 if it failed.
+func (msc *MsgServiceClient) recordDelivery(config MsgService, u User, text string, err error) {
+	now := time.Now()
+	errS := ""
+	if err != nil {
+		errS = err.Error()
+		msc.log.Printf("Error sending message to %v %v: %v\n", u.FirstName,
+			u.LastName, err)
+	}
+
+	delivered := data.BoolToFloat(err == nil)
+
+	pts := []struct {
+		id string
+		p  data.Point
+	}{
+		{config.ID, data.Point{Time: now, Type: data.PointMsgAll, Key: u.ID,
+			Value: delivered, Text: text, Origin: config.ID}},
+		{config.ID, data.Point{Time: now, Type: data.PointTypeError, Text: errS,
+			Origin: config.ID}},
+		{u.ID, data.Point{Time: now, Type: data.PointMsgUser, Key: config.ID,
+			Value: delivered, Text: text, Origin: config.ID}},
+	}
+
+	for _, p := range pts {
+		err := SendNodePoint(msc.nc, p.id, p.p, false)
+		if err != nil {
+			msc.log.Println("Error sending message point:", err)
+		}
+	}
+}
+
+// notificationUsers returns the users that should receive a notification
+// generated by a node. If the node is a user, only that user is returned.
+// Otherwise, all users that are children of the node or any of its ancestors
+// are returned.
+func notificationUsers(nc *nats.Conn, nodeID, parent string) ([]User, error) {
+	nodes, err := GetNodes(nc, "all", nodeID, "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) < 1 {
+		return nil, data.ErrDocumentNotFound
+	}
+
+	if nodes[0].Type == data.NodeTypeUser {
+		if parent == "" {
+			parent = nodes[0].Parent
+		}
+		return GetNodesType[User](nc, parent, nodeID)
+	}
+
+	levels, err := notificationUserLevels(nc, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []User
+	for _, l := range levels {
+		ret = append(ret, l...)
+	}
+
+	return ret, nil
+}
+
+// notificationUserLevels returns the users that are children of a node or
+// any of its ancestors, grouped by level in the tree. Levels without users
+// are skipped, so the first level contains the users closest to the node.
+func notificationUserLevels(nc *nats.Conn, nodeID string) ([][]User, error) {
+	var ret [][]User
+	found := make(map[string]bool)
+	treeLevel := -1
+
+	err := walkUp(nc, nodeID, func(id string, level int) (bool, error) {
+		users, err := GetNodesType[User](nc, id, "all")
+		if err != nil {
+			return false, err
+		}
+
+		for _, u := range users {
+			if found[u.ID] {
+				continue
+			}
+			found[u.ID] = true
+
+			if level != treeLevel {
+				ret = append(ret, nil)
+				treeLevel = level
+			}
+
+			ret[len(ret)-1] = append(ret[len(ret)-1], u)
+		}
+
+		return true, nil
+	})
+
+	return ret, err
+}
+
+// userMsgServices returns the nearest enabled message services of the
+// given service type, walking up the tree from the parent of a user.
+// Services found at the same level are sorted by ID.
+func userMsgServices(nc *nats.Conn, parent, service string) ([]MsgService, error) {
+	var ret []MsgService
+
+	err := walkUp(nc, parent, func(id string, _ int) (bool, error) {
+		svcs, err := GetNodesType[MsgService](nc, id, "all")
+		if err != nil {
+			return false, err
+		}
+
+		for _, s := range svcs {
+			if s.Service == service && !s.Disabled {
+				ret = append(ret, s)
+			}
+		}
+
+		return len(ret) < 1, nil
+	})
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+
+	return ret, err
+}
+
+// walkUp calls f for a node and then for its ancestors, level by level,
+// until f returns false for a node in a level or the root of the tree is
+// reached. Each node is visited once. The level of the node is passed to f,
+// starting at 0 for the node.
+func walkUp(nc *nats.Conn, id string, f func(id string, level int) (bool, error)) error {
+	visited := make(map[string]bool)
+	ids := []string{id}
+
+	for level := 0; len(ids) > 0; level++ {
+		var next []string
+		stop := false
+
+		for _, id := range ids {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+
+			cont, err := f(id, level)
+			if err != nil {
+				return err
+			}
+
+			if !cont {
+				// finish the current level before stopping
+				stop = true
+				continue
+			}
+
+			nodes, err := GetNodes(nc, "all", id, "", false)
+			if err != nil {
+				if errors.Is(err, data.ErrDocumentNotFound) {
+					continue
+				}
+				return err
+			}
+
+			for _, n := range nodes {
+				if n.Parent != "" && n.Parent != "root" && n.Parent != "none" {
+					next = append(next, n.Parent)
+				}
+			}
+		}
+
+		if stop {
+			return nil
+		}
+
+		ids = next
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package client_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/internal/smtptest"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+type smsMsg struct {
+	to   string
+	from string
+	body string
+}
+
+// fakeTwilio starts a http server that accepts Twilio send message requests
+func fakeTwilio(t *testing.T) (*httptest.Server, chan smsMsg) {
+	msgs := make(chan smsMsg, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost ||
+			req.URL.Path != "/2010-04-01/Accounts/sid123/Messages.json" {
+			t.Errorf("unexpected twilio request: %v %v", req.Method, req.URL.Path)
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		err := req.ParseForm()
+		if err != nil {
+			t.Error("Error parsing twilio form: ", err)
+		}
+
+		msgs <- smsMsg{
+			to:   req.PostForm.Get("To"),
+			from: req.PostForm.Get("From"),
+			body: req.PostForm.Get("Body"),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"sid": "SM123", "status": "queued"}`))
+	}))
+
+	return srv, msgs
+}
+
+func TestMsgServiceNotify(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	srv, msgs := fakeTwilio(t)
+	defer srv.Close()
+
+	group := client.Group{
+		ID:          "ID-group",
+		Parent:      root.ID,
+		Description: "group",
+	}
+
+	user := client.User{
+		ID:        "ID-user",
+		Parent:    root.ID,
+		FirstName: "Joe",
+		Phone:     "+15555551212",
+	}
+
+	// user without a phone number should not be messaged
+	user2 := client.User{
+		ID:        "ID-user2",
+		Parent:    root.ID,
+		FirstName: "Jane",
+	}
+
+	svc := client.MsgService{
+		ID:        "ID-twilio",
+		Parent:    root.ID,
+		Service:   data.PointValueTwilio,
+		SID:       "sid123",
+		AuthToken: "token",
+		From:      "+15555550000",
+		URI:       srv.URL,
+	}
+
+	variable := client.Variable{
+		ID:          "ID-var",
+		Parent:      group.ID,
+		Description: "var",
+	}
+
+	for _, n := range []any{group, user, user2, svc, variable} {
+		err = client.SendNodeType(nc, n, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	// wait for msg service client to start
+	time.Sleep(250 * time.Millisecond)
+
+	userGet, userStop, err := client.NodeWatcher[client.User](nc, user.ID, user.Parent)
+	if err != nil {
+		t.Fatal("Error setting up user watcher: ", err)
+	}
+	defer userStop()
+
+	not := data.Notification{
+		ID:      "ID-not",
+		Subject: "alert",
+		Message: "var is high",
+	}
+
+	d, err := not.ToPb()
+	if err != nil {
+		t.Fatal("Error encoding notification: ", err)
+	}
+
+	err = nc.Publish("node."+variable.ID+".not", d)
+	if err != nil {
+		t.Fatal("Error publishing notification: ", err)
+	}
+
+	select {
+	case m := <-msgs:
+		if m.to != user.Phone || m.from != svc.From || m.body != "alert: var is high" {
+			t.Errorf("unexpected message: %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for SMS")
+	}
+
+	select {
+	case m := <-msgs:
+		t.Fatalf("unexpected second message: %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// check that the delivery was recorded
+	start := time.Now()
+	for {
+		nodes, err := client.GetNodes(nc, user.Parent, user.ID, "", false)
+		if err != nil {
+			t.Fatal("Error getting user node: ", err)
+		}
+
+		v, ok := nodes[0].Points.Value(data.PointMsgUser, svc.ID)
+		if ok && v == 1 {
+			break
+		}
+
+		if time.Since(start) > time.Second {
+			t.Fatalf("msgUser point not set for user: %+v", userGet())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	nodes, err := client.GetNodes(nc, svc.Parent, svc.ID, "", false)
+	if err != nil {
+		t.Fatal("Error getting msg service node: ", err)
+	}
+
+	text, _ := nodes[0].Points.Text(data.PointMsgAll, user.ID)
+	if text != "alert: var is high" {
+		t.Error("msgAll point not set: ", text)
+	}
+}
+
+func TestMsgServiceNotifyEmail(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	smtpSrv, err := smtptest.NewServer(nil)
+	if err != nil {
+		t.Fatal("Error starting SMTP server: ", err)
+	}
+	defer smtpSrv.Close()
+
+	host, port := smtpSrv.Addr()
+
+	user := client.User{
+		ID:        "ID-user",
+		Parent:    root.ID,
+		FirstName: "Joe",
+		Email:     "joe@example.com",
+	}
+
+	svc := client.MsgService{
+		ID:       "ID-smtp",
+		Parent:   root.ID,
+		Service:  data.PointValueSMTP,
+		Host:     host,
+		Port:     port,
+		Security: data.PointValueNone,
+		From:     "siot@example.com",
+	}
+
+	for _, n := range []any{user, svc} {
+		err = client.SendNodeType(nc, n, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	// wait for msg service client to start
+	time.Sleep(250 * time.Millisecond)
+
+	not := data.Notification{
+		ID:      "ID-not",
+		Message: "var is high",
+	}
+
+	d, err := not.ToPb()
+	if err != nil {
+		t.Fatal("Error encoding notification: ", err)
+	}
+
+	// send notification directly to the user
+	err = nc.Publish("node."+user.ID+".not", d)
+	if err != nil {
+		t.Fatal("Error publishing notification: ", err)
+	}
+
+	select {
+	case m := <-smtpSrv.Messages:
+		if m.From != svc.From || len(m.To) != 1 || m.To[0] != user.Email {
+			t.Errorf("wrong addresses: %+v", m)
+		}
+
+		if !strings.Contains(m.Data, "var is high") {
+			t.Error("wrong message data: ", m.Data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for email")
+	}
+}
+
+func TestMsgServiceUserPreferences(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	type webhookMsg struct {
+		UserID   string `json:"userId"`
+		Message  string `json:"message"`
+		Severity string `json:"severity"`
+	}
+
+	msgs := make(chan webhookMsg, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var m webhookMsg
+		err := json.NewDecoder(req.Body).Decode(&m)
+		if err != nil {
+			t.Error("Error decoding webhook: ", err)
+		}
+		msgs <- m
+	}))
+	defer srv.Close()
+
+	// user1 only wants critical notifications, user2 has the webhook
+	// channel disabled, user3 is always in quiet hours
+	user1 := client.User{ID: "ID-user1", Parent: root.ID, FirstName: "Joe",
+		MinSeverity: data.PointValueCritical}
+	user2 := client.User{ID: "ID-user2", Parent: root.ID, FirstName: "Jane",
+		Channels: map[string]bool{data.PointValueWebhook: false}}
+	user3 := client.User{ID: "ID-user3", Parent: root.ID, FirstName: "Bob",
+		QuietStart: "0:00", QuietEnd: "0:00"}
+
+	svc := client.MsgService{
+		ID:      "ID-webhook",
+		Parent:  root.ID,
+		Service: data.PointValueWebhook,
+		URI:     srv.URL,
+	}
+
+	for _, n := range []any{user1, user2, user3, svc} {
+		err = client.SendNodeType(nc, n, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	// wait for msg service client to start
+	time.Sleep(250 * time.Millisecond)
+
+	notify := func(severity string) map[string]bool {
+		not := data.Notification{ID: "ID-not", Message: "hi", Severity: severity}
+		d, err := not.ToPb()
+		if err != nil {
+			t.Fatal("Error encoding notification: ", err)
+		}
+
+		err = nc.Publish("node."+root.ID+".not", d)
+		if err != nil {
+			t.Fatal("Error publishing notification: ", err)
+		}
+
+		ret := make(map[string]bool)
+		for {
+			select {
+			case m := <-msgs:
+				if m.Severity != severity {
+					t.Errorf("wrong severity: %v", m.Severity)
+				}
+				// ignore the default admin user
+				if strings.HasPrefix(m.UserID, "ID-") {
+					ret[m.UserID] = true
+				}
+			case <-time.After(300 * time.Millisecond):
+				return ret
+			}
+		}
+	}
+
+	got := notify(data.PointValueWarning)
+	if len(got) != 0 {
+		t.Error("warning should not be delivered: ", got)
+	}
+
+	got = notify(data.PointValueCritical)
+	if len(got) != 2 || !got[user1.ID] || !got[user3.ID] {
+		t.Error("critical should be delivered to user1 and user3: ", got)
+	}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+	"unicode/utf8"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// execOutputMax is the maximum number of bytes of stdout/stderr that are
+// written back to the action node
+const execOutputMax = 1024
+
+// execAction runs the command for an exec action. This function blocks until
+// the command exits, times out, or the rule client is stopped, so it should be
+// run in a goroutine. Exit code and output are reported through the
+// actionResults channel.
+func (rc *RuleClient) execAction(a Action, d actionTemplateData) {
+	timeout := time.Duration(a.Timeout * float64(time.Second))
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// kill the command if the rule client is stopped
+	go func() {
+		select {
+		case <-rc.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, a.Command, a.Args...)
+	cmd.Env = append(os.Environ(), execActionEnv(a, d)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// don't wait for child processes that hold the output pipes open after
+	// the command is killed
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+
+	exitCode := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		exitCode = -1
+		err = fmt.Errorf("command timed out after %v", timeout)
+	case errors.As(err, &exitErr):
+		exitCode = exitErr.ExitCode()
+		err = fmt.Errorf("command exited with status %v", exitCode)
+	case err != nil:
+		exitCode = -1
+		err = fmt.Errorf("Error running command: %w", err)
+	}
+
+	now := time.Now()
+
+	pts := data.Points{
+		{Time: now, Type: data.PointTypeExitCode, Value: float64(exitCode)},
+		{Time: now, Type: data.PointTypeStdout, Text: truncateOutput(stdout.Bytes())},
+		{Time: now, Type: data.PointTypeStderr, Text: truncateOutput(stderr.Bytes())},
+	}
+
+	select {
+	case rc.actionResults <- actionResult{id: a.ID, err: err, points: pts}:
+	case <-rc.stop:
+	}
+}
+
+// execActionEnv returns the environment variables that are passed to exec
+// action commands
+func execActionEnv(a Action, d actionTemplateData) []string {
+	active := "0"
+	if d.RuleActive {
+		active = "1"
+	}
+
+	points, _ := json.Marshal(d.TriggerNode.Points)
+
+	return []string{
+		"SIOT_RULE_ID=" + d.RuleID,
+		"SIOT_RULE_DESCRIPTION=" + d.RuleDescription,
+		"SIOT_RULE_ACTIVE=" + active,
+		"SIOT_ACTION_ID=" + a.ID,
+		"SIOT_ACTION_DESCRIPTION=" + a.Description,
+		"SIOT_TRIGGER_NODE_ID=" + d.TriggerNode.ID,
+		"SIOT_TRIGGER_NODE_TYPE=" + d.TriggerNode.Type,
+		"SIOT_TRIGGER_NODE_DESCRIPTION=" + d.TriggerNode.Desc(),
+		"SIOT_TRIGGER_POINTS=" + string(points),
+	}
+}
+
+// truncateOutput limits command output to execOutputMax bytes, keeping
+// the end of the output as that usually contains the most useful information.
+// The output is cut at a rune boundary so the result is valid UTF-8.
+func truncateOutput(b []byte) string {
+	b = bytes.TrimSpace(b)
+	if len(b) <= execOutputMax {
+		return string(b)
+	}
+
+	b = b[len(b)-execOutputMax:]
+	for len(b) > 0 && !utf8.RuneStart(b[0]) {
+		b = b[1:]
+	}
+
+	return "..." + string(b)
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	short := "hello"
+	if truncateOutput([]byte(short)) != short {
+		t.Error("short output should not be truncated")
+	}
+
+	// the cut point lands in the middle of a 3 byte rune
+	out := "start " + strings.Repeat("€", execOutputMax/3+10) + "xy"
+	ret := truncateOutput([]byte(out))
+
+	if !utf8.ValidString(ret) {
+		t.Fatal("truncated output is not valid UTF-8")
+	}
+
+	if !strings.HasPrefix(ret, "...€") || !strings.HasSuffix(ret, "€xy") {
+		t.Error("truncated output should keep the end: ", ret[:10])
+	}
+
+	if len(ret) > execOutputMax+3 {
+		t.Error("truncated output too long: ", len(ret))
+	}
+}
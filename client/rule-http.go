@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpActionMaxBackoff is the maximum time between http action retries
+const httpActionMaxBackoff = time.Minute
+
+// httpAction sends the http request for an action. This function blocks until
+// the request is complete or all retries have failed, so it should be run in
+// a goroutine. The result is reported through the actionResults channel.
+func (rc *RuleClient) httpAction(a Action, d actionTemplateData) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancel any request in progress if the rule client is stopped
+	go func() {
+		select {
+		case <-rc.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var err error
+
+	for attempt := 0; attempt <= a.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(ExpBackoff(attempt-1, httpActionMaxBackoff)):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		var retry bool
+		retry, err = httpActionRequest(ctx, a, d)
+		if err == nil || !retry {
+			break
+		}
+
+		log.Printf("Rule http action %v attempt %v failed: %v\n", a.Description,
+			attempt+1, err)
+	}
+
+	select {
+	case rc.actionResults <- actionResult{id: a.ID, err: err}:
+	case <-rc.stop:
+	}
+}
+
+// httpActionRequest sends a single http request. Returns true if the request
+// should be retried.
+func httpActionRequest(ctx context.Context, a Action, d actionTemplateData) (bool, error) {
+	body, err := executeActionTemplate("body", a.Body, d)
+	if err != nil {
+		return false, err
+	}
+
+	method := a.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	timeout := time.Duration(a.Timeout * float64(time.Second))
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), a.URI,
+		strings.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("Error creating http request: %w", err)
+	}
+
+	for k, v := range a.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("http request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// read some of the body so the connection can be reused and so we
+	// can include it in errors
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("http status: %v %s", resp.Status,
+			strings.TrimSpace(string(respBody)))
+	}
+
+	return false, nil
+}
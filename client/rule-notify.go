@@ -0,0 +1,185 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// notify runs a notify action. A new notification is opened and stays open
+// until a user acknowledges it by setting the ack point on the action. If
+// repeat is set, an open notification is sent again to all users that have
+// been notified so far.
+func (rc *RuleClient) notify(actions []Action, i int, triggerNodeID string, repeat bool) error {
+	a := actions[i]
+
+	if repeat {
+		st, ok := rc.actionStates[a.ID]
+		if !a.Open || !ok {
+			// notification was acknowledged
+			return nil
+		}
+
+		return rc.sendNotification(a, st.not, 0, a.EscalationLevel)
+	}
+
+	// get node that fired the rule
+	nodes, err := GetNodes(rc.nc, "all", triggerNodeID, "", false)
+	if err != nil {
+		return err
+	}
+
+	if len(nodes) < 1 {
+		return fmt.Errorf("trigger node not found")
+	}
+
+	n := data.Notification{
+		ID:         uuid.New().String(),
+		SourceNode: a.NodeID,
+		Message:    rc.config.Description + " fired at " + nodes[0].Desc(),
+		Severity:   a.Severity,
+	}
+
+	now := time.Now()
+
+	pts := data.Points{
+		{Time: now, Type: data.PointTypeOpen, Value: 1},
+		{Time: now, Type: data.PointTypeAck, Text: ""},
+		{Time: now, Type: data.PointTypeEscalationLevel, Value: 0},
+	}
+
+	for _, p := range pts {
+		err := rc.sendPoint(a.ID, p)
+		if err != nil {
+			log.Println("Error sending notification point:", err)
+		}
+	}
+
+	actions[i].Open = true
+	actions[i].Ack = ""
+	actions[i].EscalationLevel = 0
+
+	if a.Repeat > 0 || a.Escalation > 0 {
+		st := rc.actionState(a.ID, triggerNodeID)
+		st.not = n
+		if a.Escalation > 0 {
+			st.escalate = now.Add(minutesToDuration(a.Escalation))
+		}
+	}
+
+	return rc.sendNotification(actions[i], n, 0, 0)
+}
+
+// escalateNotification sends an open notification to the next level of users
+func (rc *RuleClient) escalateNotification(actions []Action, i int, st *actionState, now time.Time) {
+	a := actions[i]
+	st.escalate = time.Time{}
+
+	if !a.Open || a.Escalation <= 0 {
+		return
+	}
+
+	levels, err := notificationUserLevels(rc.nc, rc.config.ID)
+	if err != nil {
+		log.Println("Error getting notification users:", err)
+		return
+	}
+
+	level := a.EscalationLevel + 1
+	if level >= len(levels) {
+		// no more users to escalate to
+		return
+	}
+
+	err = rc.sendPoint(a.ID, data.Point{
+		Time:  now,
+		Type:  data.PointTypeEscalationLevel,
+		Value: float64(level),
+	})
+	if err != nil {
+		log.Println("Error sending notification point:", err)
+	}
+
+	actions[i].EscalationLevel = level
+
+	err = rc.sendNotification(actions[i], st.not, level, level)
+	if err != nil {
+		log.Println("Error sending notification:", err)
+	}
+
+	st.escalate = now.Add(minutesToDuration(a.Escalation))
+}
+
+// sendNotification sends a notification to the users in levels from through
+// to. Levels are groups of users found walking up the tree from the rule. If
+// escalation is not enabled for the action, the notification is sent to all
+// users.
+func (rc *RuleClient) sendNotification(a Action, n data.Notification, from, to int) error {
+	if a.Escalation <= 0 {
+		d, err := n.ToPb()
+		if err != nil {
+			return err
+		}
+
+		return rc.nc.Publish("node."+rc.config.ID+".not", d)
+	}
+
+	levels, err := notificationUserLevels(rc.nc, rc.config.ID)
+	if err != nil {
+		return err
+	}
+
+	for l := from; l <= to && l < len(levels); l++ {
+		for _, u := range levels[l] {
+			n.Parent = u.Parent
+			d, err := n.ToPb()
+			if err != nil {
+				return err
+			}
+
+			err = rc.nc.Publish("node."+u.ID+".not", d)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// processAcks closes notifications that have been acknowledged and cancels
+// their repeat and escalation
+func (rc *RuleClient) processAcks() {
+	for _, actions := range [][]Action{rc.config.Actions, rc.config.ActionsInactive} {
+		for i, a := range actions {
+			if a.Action != data.PointValueNotify || !a.Open || a.Ack == "" {
+				continue
+			}
+
+			err := rc.sendPoint(a.ID, data.Point{
+				Time:  time.Now(),
+				Type:  data.PointTypeOpen,
+				Value: 0,
+			})
+			if err != nil {
+				log.Println("Error sending notification point:", err)
+			}
+
+			actions[i].Open = false
+
+			if st, ok := rc.actionStates[a.ID]; ok {
+				st.next = time.Time{}
+				st.escalate = time.Time{}
+			}
+		}
+	}
+
+	rc.armActionTimer()
+}
+
+func minutesToDuration(m float64) time.Duration {
+	return time.Duration(m * float64(time.Minute))
+}
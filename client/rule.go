@@ -1,40 +1,54 @@
 package client
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/go-audio/wav"
-	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/simpleiot/simpleiot/data"
 )
 
 // Rule represent a rule node config
 type Rule struct {
-	ID              string      `node:"id"`
-	Parent          string      `node:"parent"`
-	Description     string      `point:"description"`
-	Disabled        bool        `point:"disabled"`
-	Active          bool        `point:"active"`
-	Error           string      `point:"error"`
-	Conditions      []Condition `child:"condition"`
-	Actions         []Action    `child:"action"`
-	ActionsInactive []Action    `child:"actionInactive"`
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Disabled    bool   `point:"disabled"`
+	Active      bool   `point:"active"`
+	Error       string `point:"error"`
+	// ConditionOperator: and (default), or
+	ConditionOperator string           `point:"conditionOperator"`
+	Conditions        []Condition      `child:"condition"`
+	ConditionGroups   []ConditionGroup `child:"conditionGroup"`
+	Actions           []Action         `child:"action"`
+	ActionsInactive   []Action         `child:"actionInactive"`
 }
 
 func (r Rule) String() string {
 	ret := fmt.Sprintf("Rule: %v\n", r.Description)
 	ret += fmt.Sprintf("  active: %v\n", r.Active)
 	ret += fmt.Sprintf("  Disabled: %v\n", r.Disabled)
+	if r.ConditionOperator != "" {
+		ret += fmt.Sprintf("  Operator: %v\n", r.ConditionOperator)
+	}
 	for _, c := range r.Conditions {
 		ret += fmt.Sprintf("%v", c)
 	}
+	for _, g := range r.ConditionGroups {
+		ret += g.string("  ")
+	}
 	for _, a := range r.Actions {
 		ret += fmt.Sprintf("  ACTION: %v", a)
 	}
@@ -55,8 +69,11 @@ type Condition struct {
 	Disabled      bool    `point:"disabled"`
 	ConditionType string  `point:"conditionType"`
 	MinActive     float64 `point:"minActive"`
+	MinInactive   float64 `point:"minInactive"`
 	Active        bool    `point:"active"`
 	Error         string  `point:"error"`
+	// Invert negates the result of the condition (NOT)
+	Invert bool `point:"invert"`
 
 	// used with point value rules
 	NodeID     string  `point:"nodeID"`
@@ -67,12 +84,29 @@ type Condition struct {
 	Operator   string  `point:"operator"`
 	Value      float64 `point:"value"`
 	ValueText  string  `point:"valueText"`
+	// Value2 is the 2nd limit for the between and outside operators
+	Value2 float64 `point:"value2"`
+	// Hysteresis is the deadband a number must cross back over before
+	// the comparison goes false once it has been true
+	Hysteresis float64 `point:"hysteresis"`
+
+	// used with stale rules, timeout is in minutes
+	Timeout float64 `point:"timeout"`
+
+	// used with point window rules, window is in minutes
+	// WindowFunction: delta, slope, min, max, mean
+	Window         float64 `point:"window"`
+	WindowFunction string  `point:"windowFunction"`
 
 	// used with shedule rules
 	Start    string   `point:"start"`
 	End      string   `point:"end"`
 	Weekdays []bool   `point:"weekday"`
 	Dates    []string `point:"date"`
+	// Timezone is an IANA time zone name (ex: America/New_York), or
+	// local for the time zone of the instance. If blank, UTC is used as
+	// the frontend stores schedules in UTC.
+	Timezone string `point:"timezone"`
 }
 
 func (c Condition) String() string {
@@ -86,6 +120,9 @@ func (c Condition) String() string {
 		}
 	case data.PointValueNumber:
 		value = strconv.FormatFloat(c.Value, 'f', 2, 64)
+		if c.Operator == data.PointValueBetween || c.Operator == data.PointValueOutside {
+			value += ".." + strconv.FormatFloat(c.Value2, 'f', 2, 64)
+		}
 	case data.PointValueText:
 		value = c.ValueText
 	}
@@ -99,9 +136,41 @@ func (c Condition) String() string {
 		if c.NodeID != "" {
 			ret += fmt.Sprintf("  NODEID:%v", c.NodeID)
 		}
+		if c.Operator != "" {
+			ret += fmt.Sprintf("  OP:%v", c.Operator)
+		}
+		if c.Hysteresis > 0 {
+			ret += fmt.Sprintf("  HYST:%v", c.Hysteresis)
+		}
 		if c.MinActive > 0 {
 			ret += fmt.Sprintf("  MINACT:%v", c.MinActive)
 		}
+		if c.MinInactive > 0 {
+			ret += fmt.Sprintf("  MININACT:%v", c.MinInactive)
+		}
+		if c.Invert {
+			ret += "  NOT"
+		}
+		ret += fmt.Sprintf("  A:%v", c.Active)
+		ret += "\n"
+	case data.PointValuePointWindow:
+		ret = fmt.Sprintf("  COND: %v  CTYPE:%v  FUNC:%v  WINDOW:%v  OP:%v  V:%v",
+			c.Description, c.ConditionType, c.WindowFunction, c.Window,
+			c.Operator, value)
+		if c.NodeID != "" {
+			ret += fmt.Sprintf("  NODEID:%v", c.NodeID)
+		}
+		ret += fmt.Sprintf("  A:%v", c.Active)
+		ret += "\n"
+	case data.PointValueStale:
+		ret = fmt.Sprintf("  COND: %v  CTYPE:%v  NODEID:%v  TIMEOUT:%v",
+			c.Description, c.ConditionType, c.NodeID, c.Timeout)
+		if c.PointType != "" {
+			ret += fmt.Sprintf("  T:%v", c.PointType)
+		}
+		if c.PointKey != "" {
+			ret += fmt.Sprintf("  K:%v", c.PointKey)
+		}
 		ret += fmt.Sprintf("  A:%v", c.Active)
 		ret += "\n"
 	case data.PointValueSchedule:
@@ -109,6 +178,9 @@ func (c Condition) String() string {
 			c.Description, c.ConditionType)
 		ret += fmt.Sprintf("  W:%v", c.Weekdays)
 		ret += fmt.Sprintf("  D:%v", c.Dates)
+		if c.Timezone != "" {
+			ret += fmt.Sprintf("  TZ:%v", c.Timezone)
+		}
 		ret += "\n"
 
 	default:
@@ -117,6 +189,60 @@ func (c Condition) String() string {
 	return ret
 }
 
+// ConditionGroup is used to group conditions so they can be combined with a
+// different operator than the rule. Groups may be nested.
+type ConditionGroup struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Disabled    bool   `point:"disabled"`
+	Active      bool   `point:"active"`
+	// ConditionOperator: and (default), or
+	ConditionOperator string `point:"conditionOperator"`
+	// Invert negates the result of the group (NOT)
+	Invert          bool             `point:"invert"`
+	Conditions      []Condition      `child:"condition"`
+	ConditionGroups []ConditionGroup `child:"conditionGroup"`
+}
+
+func (g ConditionGroup) String() string {
+	return g.string("  ")
+}
+
+func (g ConditionGroup) string(indent string) string {
+	op := g.ConditionOperator
+	if op == "" {
+		op = data.PointValueAnd
+	}
+	ret := fmt.Sprintf("%vGROUP: %v  Disabled:%v  OP:%v", indent, g.Description,
+		g.Disabled, op)
+	if g.Invert {
+		ret += "  NOT"
+	}
+	ret += fmt.Sprintf("  A:%v\n", g.Active)
+	for _, c := range g.Conditions {
+		ret += indent + fmt.Sprintf("%v", c)
+	}
+	for _, cg := range g.ConditionGroups {
+		ret += cg.string(indent + "  ")
+	}
+	return ret
+}
+
+// schedule returns the schedule for a schedule condition
+func (c Condition) schedule() (*schedule, error) {
+	loc := time.UTC
+	if c.Timezone != "" {
+		var err error
+		loc, err = scheduleLocation(c.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newSchedule(c.Start, c.End, scheduleWeekdays(c.Weekdays), c.Dates, loc), nil
+}
+
 // Action defines actions that can be taken if a rule is active.
 type Action struct {
 	ID          string `node:"id"`
@@ -125,7 +251,7 @@ type Action struct {
 	Disabled    bool   `point:"disabled"`
 	Active      bool   `point:"active"`
 	Error       string `point:"error"`
-	// Action: notify, setValue, playAudio
+	// Action: notify, setValue, playAudio, http, exec, alarm
 	Action    string `point:"action"`
 	NodeID    string `point:"nodeID"`
 	PointType string `point:"pointType"`
@@ -138,6 +264,32 @@ type Action struct {
 	PointChannel  int    `point:"pointChannel"`
 	PointDevice   string `point:"pointDevice"`
 	PointFilePath string `point:"pointFilePath"`
+	// the following are used for http requests. Body is a Go
+	// text/template, timeout is in seconds and is also used by exec.
+	URI     string            `point:"uri"`
+	Method  string            `point:"method"`
+	Headers map[string]string `point:"header"`
+	Body    string            `point:"body"`
+	Timeout float64           `point:"timeout"`
+	Retries int               `point:"retries"`
+	// the following are used to run commands. ExitCode, Stdout, and
+	// Stderr are written by the rule when the command completes.
+	Command  string   `point:"command"`
+	Args     []string `point:"arg"`
+	ExitCode int      `point:"exitCode"`
+	Stdout   string   `point:"stdout"`
+	Stderr   string   `point:"stderr"`
+	// actions are run in order of index. Delay and repeat are in seconds.
+	Index  float64 `point:"index"`
+	Delay  float64 `point:"delay"`
+	Repeat float64 `point:"repeat"`
+	// the following are used for notifications. Escalation is in minutes.
+	// Severity: info, warning, critical
+	Severity        string  `point:"severity"`
+	Escalation      float64 `point:"escalation"`
+	Open            bool    `point:"open"`
+	Ack             string  `point:"ack"`
+	EscalationLevel int     `point:"escalationLevel"`
 }
 
 func (a Action) String() string {
@@ -159,6 +311,12 @@ func (a Action) String() string {
 	if a.NodeID != "" {
 		ret += fmt.Sprintf("  NODEID:%v", a.NodeID)
 	}
+	if a.URI != "" {
+		ret += fmt.Sprintf("  %v %v", a.Method, a.URI)
+	}
+	if a.Command != "" {
+		ret += fmt.Sprintf("  CMD:%v %v", a.Command, strings.Join(a.Args, " "))
+	}
 	if a.PointKey != "" && a.PointKey != "0" {
 		ret += fmt.Sprintf(" K:%v", a.PointKey)
 	}
@@ -174,7 +332,7 @@ type ActionInactive struct {
 	Parent      string `node:"parent"`
 	Description string `point:"description"`
 	Active      bool   `point:"active"`
-	// Action: notify, setValue, playAudio
+	// Action: notify, setValue, playAudio, http, exec, alarm
 	Action    string `point:"action"`
 	NodeID    string `point:"nodeID"`
 	PointType string `point:"pointType"`
@@ -187,6 +345,28 @@ type ActionInactive struct {
 	PointChannel  int    `point:"pointChannel"`
 	PointDevice   string `point:"pointDevice"`
 	PointFilePath string `point:"pointFilePath"`
+	// the following are used for http requests. Body is a Go
+	// text/template, timeout is in seconds and is also used by exec.
+	URI     string            `point:"uri"`
+	Method  string            `point:"method"`
+	Headers map[string]string `point:"header"`
+	Body    string            `point:"body"`
+	Timeout float64           `point:"timeout"`
+	Retries int               `point:"retries"`
+	// the following are used to run commands
+	Command string   `point:"command"`
+	Args    []string `point:"arg"`
+	// actions are run in order of index. Delay and repeat are in seconds.
+	Index  float64 `point:"index"`
+	Delay  float64 `point:"delay"`
+	Repeat float64 `point:"repeat"`
+	// the following are used for notifications. Escalation is in minutes.
+	// Severity: info, warning, critical
+	Severity        string  `point:"severity"`
+	Escalation      float64 `point:"escalation"`
+	Open            bool    `point:"open"`
+	Ack             string  `point:"ack"`
+	EscalationLevel int     `point:"escalationLevel"`
 }
 
 // RuleClient is a SIOT client used to run rules
@@ -198,17 +378,94 @@ type RuleClient struct {
 	newEdgePoints chan NewPoints
 	newRulePoints chan NewPoints
 	upSub         *nats.Subscription
+	// raw (before min active/inactive delays) condition state
+	// indexed by condition ID
+	conditionStates map[string]*conditionState
+	conditionTimer  *time.Timer
+	// results from actions that run in the background
+	actionResults chan actionResult
+	// delayed and repeating actions, indexed by action ID
+	actionStates map[string]*actionState
+	actionTimer  *time.Timer
+}
+
+// actionState tracks when a delayed or repeating action runs next, and
+// when a notification escalates
+type actionState struct {
+	next          time.Time
+	triggerNodeID string
+	// ran is set after the first run, following runs are repeats
+	ran bool
+	// notification escalation
+	escalate time.Time
+	not      data.Notification
+}
+
+// nextTime returns the next time the action state needs processed
+func (st *actionState) nextTime() time.Time {
+	if st.next.IsZero() || (!st.escalate.IsZero() && st.escalate.Before(st.next)) {
+		return st.escalate
+	}
+	return st.next
+}
+
+// actionResult is used to report the result of an action that runs
+// in the background
+type actionResult struct {
+	id     string
+	err    error
+	points data.Points
+}
+
+// conditionState tracks the raw state of a condition and when it
+// last changed
+type conditionState struct {
+	active  bool
+	changed time.Time
+	// last result of the value comparison, before invert
+	// and delays are applied. This is used for hysteresis.
+	compared bool
+	// last time a matching point was received, used for
+	// stale conditions
+	updated time.Time
+	// stale condition config the update time was initialized for
+	staleConfig string
+	// point windows indexed by node ID, used for point window conditions
+	windows map[string]*pointWindow
+	// compiled regex for text conditions, recompiled when the
+	// condition value changes
+	re     *regexp.Regexp
+	reText string
+}
+
+// pointWindow is the window of points from one node for a point window
+// condition
+type pointWindow struct {
+	window *data.PointWindow
+	// last result of the value comparison, used for hysteresis
+	compared bool
 }
 
 // NewRuleClient constructor ...
 func NewRuleClient(nc *nats.Conn, config Rule) Client {
+	conditionTimer := time.NewTimer(time.Hour)
+	conditionTimer.Stop()
+
+	actionTimer := time.NewTimer(time.Hour)
+	actionTimer.Stop()
+
 	return &RuleClient{
-		nc:            nc,
-		config:        config,
-		stop:          make(chan struct{}),
-		newPoints:     make(chan NewPoints),
-		newEdgePoints: make(chan NewPoints),
-		newRulePoints: make(chan NewPoints),
+		nc:              nc,
+		config:          config,
+		stop:            make(chan struct{}),
+		newPoints:       make(chan NewPoints),
+		newEdgePoints:   make(chan NewPoints),
+		newRulePoints:   make(chan NewPoints),
+		conditionStates: make(map[string]*conditionState),
+		conditionTimer:  conditionTimer,
+		actionResults:   make(chan actionResult),
+		actionStates:    make(map[string]*actionState),
+		actionTimer:     actionTimer,
 	}
 }
 
@@ -241,13 +498,43 @@ func (rc *RuleClient) Run() error {
 		return fmt.Errorf("Rule error subscribing to upsub: %v", err)
 	}
 
-	// TODO schedule ticker is a brute force way to do this
-	// we could optimize at some point by creating a timer to expire
-	// on the next schedule change
-	scheduleTickTime := time.Second * 10
-	scheduleTicker := time.NewTicker(scheduleTickTime)
-	if !rc.hasSchedule() {
-		scheduleTicker.Stop()
+	// the schedule timer is set to expire on the next schedule change
+	scheduleTimer := time.NewTimer(time.Hour)
+	scheduleTimer.Stop()
+
+	armScheduleTimer := func() {
+		scheduleTimer.Stop()
+		if next, ok := rc.nextScheduleTransition(time.Now()); ok {
+			scheduleTimer.Reset(next)
+		}
+	}
+
+	armScheduleTimer()
+
+	rc.initStaleConditions()
+
+	runActions := func(active bool, id string) {
+		if active {
+			err := rc.ruleRunActions(rc.config.Actions, id)
+			if err != nil {
+				log.Println("Error running rule actions:", err)
+			}
+
+			err = rc.ruleInactiveActions(rc.config.ActionsInactive)
+			if err != nil {
+				log.Println("Error running rule inactive actions:", err)
+			}
+		} else {
+			err := rc.ruleRunActions(rc.config.ActionsInactive, id)
+			if err != nil {
+				log.Println("Error running rule actions:", err)
+			}
+
+			err = rc.ruleInactiveActions(rc.config.Actions)
+			if err != nil {
+				log.Println("Error running rule inactive actions:", err)
+			}
+		}
 	}
 
 	run := func(id string, pts data.Points) {
@@ -280,27 +567,7 @@ func (rc *RuleClient) Run() error {
 			}
 		}
 
-		if active {
-			err := rc.ruleRunActions(rc.config.Actions, id)
-			if err != nil {
-				log.Println("Error running rule actions:", err)
-			}
-
-			err = rc.ruleInactiveActions(rc.config.ActionsInactive)
-			if err != nil {
-				log.Println("Error running rule inactive actions:", err)
-			}
-		} else {
-			err := rc.ruleRunActions(rc.config.ActionsInactive, id)
-			if err != nil {
-				log.Println("Error running rule actions:", err)
-			}
-
-			err = rc.ruleInactiveActions(rc.config.Actions)
-			if err != nil {
-				log.Println("Error running rule inactive actions:", err)
-			}
-		}
+		runActions(active, id)
 	}
 
 done:
@@ -312,14 +579,23 @@ done:
 			// make sure the point is in a condition before we run the rule
 			// otherwise, we can get into a loop
 			found := false
-			for _, c := range rc.config.Conditions {
-				if c.ConditionType != data.PointValuePointValue {
+			for _, c := range rc.conditions() {
+				if c.ConditionType != data.PointValuePointValue &&
+					c.ConditionType != data.PointValuePointWindow &&
+					c.ConditionType != data.PointValueStale {
 					continue
 				}
 				if c.NodeID == pts.ID {
 					found = true
 					break
 				}
+				// a blank node ID on a point window condition matches
+				// any node except the nodes of this rule
+				if c.NodeID == "" && c.ConditionType == data.PointValuePointWindow &&
+					!rc.ruleNode(pts.ID) {
+					found = true
+					break
+				}
 			}
 
 			if found {
@@ -327,23 +603,49 @@ done:
 				run(pts.ID, pts.Points)
 			}
 
-		case <-scheduleTicker.C:
+		case <-scheduleTimer.C:
 			run(rc.config.ID, data.Points{{
 				Time: time.Now(),
 				Type: data.PointTypeTrigger,
 			}})
 
+			armScheduleTimer()
+
+		case <-rc.actionTimer.C:
+			rc.ruleProcessActionTimer(time.Now())
+
+		case r := <-rc.actionResults:
+			// the error is set first so it is current once the
+			// result points are seen
+			rc.actionError(r.id, r.err)
+			for _, p := range r.points {
+				err := rc.sendPoint(r.id, p)
+				if err != nil {
+					log.Println("Rule error sending action result point:", err)
+				}
+			}
+
+		case <-rc.conditionTimer.C:
+			if rc.config.Disabled {
+				break
+			}
+
+			active, changed := rc.ruleProcessConditionTimers(time.Now())
+			if changed {
+				runActions(active, rc.config.ID)
+			}
+
 		case pts := <-rc.newPoints:
 			err := data.MergePoints(pts.ID, pts.Points, &rc.config)
 			if err != nil {
 				log.Println("error merging rule points:", err)
 			}
 
-			if rc.hasSchedule() {
-				scheduleTicker = time.NewTicker(scheduleTickTime)
-			} else {
-				scheduleTicker.Stop()
-			}
+			rc.processAcks()
+
+			armScheduleTimer()
+
+			rc.initStaleConditions()
 
 			run("", nil)
 
@@ -357,9 +659,51 @@ done:
 		}
 	}
 
+	rc.conditionTimer.Stop()
+	scheduleTimer.Stop()
+
 	return rc.upSub.Unsubscribe()
 }
 
+// actionError sets or clears the error for an action
+func (rc *RuleClient) actionError(id string, err error) {
+	errS := ""
+	if err != nil {
+		errS = err.Error()
+	}
+
+	for _, actions := range [][]Action{rc.config.Actions, rc.config.ActionsInactive} {
+		for i, a := range actions {
+			if a.ID != id {
+				continue
+			}
+
+			if a.Error != errS {
+				if err != nil {
+					log.Printf("Rule action error %v:%v:%v\n", rc.config.Description,
+						a.Description, err)
+				}
+
+				p := data.Point{
+					Type: data.PointTypeError,
+					Time: time.Now(),
+					Text: errS,
+				}
+
+				err := rc.sendPoint(a.ID, p)
+				if err != nil {
+					log.Println("Rule error sending point:", err)
+				} else {
+					actions[i].Error = errS
+				}
+			}
+
+			rc.processError(errS)
+			return
+		}
+	}
+}
+
 // Stop sends a signal to the Run function to exit
 func (rc *RuleClient) Stop(_ error) {
 	close(rc.stop)
@@ -390,15 +734,102 @@ func (rc *RuleClient) sendPoint(id string, point data.Point) error {
 	return SendNodePoint(rc.nc, id, point, false)
 }
 
-func (rc *RuleClient) hasSchedule() bool {
-	for _, c := range rc.config.Conditions {
-		if c.ConditionType == data.PointValueSchedule {
+// conditions returns pointers to all conditions in the rule, including
+// those in condition groups.
+func (rc *RuleClient) conditions() []*Condition {
+	var ret []*Condition
+
+	var groupHelper func(conds []Condition, groups []ConditionGroup)
+	groupHelper = func(conds []Condition, groups []ConditionGroup) {
+		for i := range conds {
+			ret = append(ret, &conds[i])
+		}
+		for i := range groups {
+			groupHelper(groups[i].Conditions, groups[i].ConditionGroups)
+		}
+	}
+
+	groupHelper(rc.config.Conditions, rc.config.ConditionGroups)
+
+	return ret
+}
+
+// ruleNode returns true if id is the rule or one of its conditions,
+// condition groups, or actions
+func (rc *RuleClient) ruleNode(id string) bool {
+	if id == rc.config.ID {
+		return true
+	}
+
+	for _, c := range rc.conditions() {
+		if c.ID == id {
 			return true
 		}
 	}
+
+	var groupHelper func(groups []ConditionGroup) bool
+	groupHelper = func(groups []ConditionGroup) bool {
+		for _, g := range groups {
+			if g.ID == id || groupHelper(g.ConditionGroups) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if groupHelper(rc.config.ConditionGroups) {
+		return true
+	}
+
+	for _, actions := range [][]Action{rc.config.Actions, rc.config.ActionsInactive} {
+		for _, a := range actions {
+			if a.ID == id {
+				return true
+			}
+		}
+	}
+
 	return false
 }
 
+// scheduleMaxWait is the maximum time we wait before re-evaluating schedules.
+// The schedule timer uses the monotonic clock, so this limits how long a
+// wall clock change (NTP sync, time zone change, etc.) goes unnoticed.
+const scheduleMaxWait = 10 * time.Minute
+
+// nextScheduleTransition returns the duration until the next schedule
+// condition may change state. Returns false if there are no schedule
+// conditions.
+func (rc *RuleClient) nextScheduleTransition(now time.Time) (time.Duration, bool) {
+	hasSchedule := false
+	next := scheduleMaxWait
+
+	for _, c := range rc.conditions() {
+		if c.ConditionType != data.PointValueSchedule || c.Disabled {
+			continue
+		}
+
+		hasSchedule = true
+
+		sched, err := c.schedule()
+		if err != nil {
+			// error is reported when the schedule is evaluated
+			continue
+		}
+
+		t, ok, err := sched.nextTransition(now)
+		if err != nil || !ok {
+			continue
+		}
+
+		if d := t.Sub(now); d < next {
+			next = d
+		}
+	}
+
+	return next, hasSchedule
+}
+
 func (rc *RuleClient) processError(errS string) {
 	if errS != "" {
 		// always set rule error to the last error we encounter
@@ -420,7 +851,7 @@ func (rc *RuleClient) processError(errS string) {
 		// check if any other errors still exist
 		found := ""
 
-		for _, c := range rc.config.Conditions {
+		for _, c := range rc.conditions() {
 			if c.Error != "" {
 				found = c.Error
 				break
@@ -464,7 +895,8 @@ func (rc *RuleClient) processError(errS string) {
 // handle all current uses.
 func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool, bool, error) {
 	for _, p := range points {
-		for i, c := range rc.config.Conditions {
+		for _, cp := range rc.conditions() {
+			c := *cp
 			var active bool
 			var errorActive bool
 
@@ -483,7 +915,7 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 					if err != nil {
 						log.Println("Rule error sending point:", err)
 					} else {
-						rc.config.Conditions[i].Error = errS
+						cp.Error = errS
 					}
 				}
 				rc.processError(errS)
@@ -505,21 +937,35 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 				// conditions match, so check value
 				switch c.ValueType {
 				case data.PointValueNumber:
-					switch c.Operator {
-					case data.PointValueGreaterThan:
-						active = p.Value > c.Value
-					case data.PointValueLessThan:
-						active = p.Value < c.Value
-					case data.PointValueEqual:
-						active = p.Value == c.Value
-					case data.PointValueNotEqual:
-						active = p.Value != c.Value
+					st := rc.conditionState(c, time.Now())
+					var err error
+					active, err = numberCompare(c, p.Value, st.compared)
+					if err != nil {
+						processError(err)
+						break
 					}
+					st.compared = active
 				case data.PointValueText:
 					switch c.Operator {
 					case data.PointValueEqual:
+						active = p.Text == c.ValueText
 					case data.PointValueNotEqual:
+						active = p.Text != c.ValueText
 					case data.PointValueContains:
+						active = strings.Contains(p.Text, c.ValueText)
+					case data.PointValueStartsWith:
+						active = strings.HasPrefix(p.Text, c.ValueText)
+					case data.PointValueEndsWith:
+						active = strings.HasSuffix(p.Text, c.ValueText)
+					case data.PointValueRegex:
+						re, err := rc.conditionState(c, time.Now()).regex(c)
+						if err != nil {
+							processError(fmt.Errorf("invalid regex %v: %w", c.ValueText, err))
+							break
+						}
+						active = re.MatchString(p.Text)
+					default:
+						processError(fmt.Errorf("unknown text operator: %v", c.Operator))
 					}
 				case data.PointValueOnOff:
 					condValue := c.Value != 0
@@ -528,43 +974,77 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 				default:
 					processError(fmt.Errorf("unknown value type: %v", c.ValueType))
 				}
-			case data.PointValueSchedule:
-				if p.Type != data.PointTypeTrigger {
+			case data.PointValuePointWindow:
+				if c.NodeID != "" && c.NodeID != nodeID {
 					continue
 				}
 
-				weekdays := []time.Weekday{}
-				for i, v := range c.Weekdays {
-					if v {
-						weekdays = append(weekdays, time.Weekday(i))
-					}
+				if c.PointKey != "" && c.PointKey != p.Key {
+					continue
+				}
+
+				if c.PointType != "" && c.PointType != p.Type {
+					continue
 				}
-				sched := newSchedule(c.Start, c.End, weekdays, c.Dates)
 
+				now := time.Now()
 				var err error
-				active, err = sched.activeForTime(p.Time)
+				active, err = rc.conditionState(c, now).windowActive(c, nodeID, &p, now)
 				if err != nil {
-					processError(fmt.Errorf("Error parsing schedule: %w", err))
+					processError(err)
+				}
+			case data.PointValueStale:
+				if c.NodeID != nodeID {
 					continue
 				}
-			}
 
-			if active != c.Active {
-				// update condition
-				p := data.Point{
-					Type:  data.PointTypeActive,
-					Time:  time.Now(),
-					Value: data.BoolToFloat(active),
+				if c.PointKey != "" && c.PointKey != p.Key {
+					continue
 				}
 
-				err := rc.sendPoint(c.ID, p)
+				if c.PointType != "" && c.PointType != p.Type {
+					continue
+				}
+
+				now := time.Now()
+				st := rc.conditionState(c, now)
+				t := p.Time
+				if t.IsZero() || t.After(now) {
+					t = now
+				}
+				if t.After(st.updated) {
+					st.updated = t
+				}
+
+				var err error
+				active, err = st.stale(c, now)
 				if err != nil {
-					log.Println("Rule error sending point:", err)
+					processError(err)
+				}
+			case data.PointValueSchedule:
+				if p.Type != data.PointTypeTrigger {
+					continue
+				}
+
+				sched, err := c.schedule()
+				if err != nil {
+					processError(fmt.Errorf("Error parsing schedule: %w", err))
+					continue
 				}
 
-				rc.config.Conditions[i].Active = active
+				active, err = sched.activeForTime(p.Time)
+				if err != nil {
+					processError(fmt.Errorf("Error parsing schedule: %w", err))
+					continue
+				}
+			}
+
+			if c.Invert && !errorActive {
+				active = !active
 			}
 
+			rc.setConditionActive(cp, rc.conditionDelay(c, active, time.Now()))
+
 			if !errorActive && c.Error != "" {
 				p := data.Point{
 					Type: data.PointTypeError,
@@ -576,29 +1056,25 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 				if err != nil {
 					log.Println("Rule error sending point:", err)
 				} else {
-					rc.config.Conditions[i].Error = ""
+					cp.Error = ""
 				}
 				rc.processError("")
 			}
 		}
 	}
 
-	allActive := true
-	activeConditionCount := 0
+	rc.armConditionTimer(time.Now())
 
-	for _, c := range rc.config.Conditions {
-		if !c.Active && !c.Disabled {
-			allActive = false
-			break
-		}
-		if c.Active && !c.Disabled {
-			activeConditionCount++
-		}
-	}
+	allActive, changed := rc.ruleUpdateActive()
 
-	if activeConditionCount == 0 && allActive {
-		allActive = false
-	}
+	return allActive, changed, nil
+}
+
+// ruleUpdateActive combines the condition states and updates the rule active
+// point. Returns the rule active state and if it changed.
+func (rc *RuleClient) ruleUpdateActive() (bool, bool) {
+	allActive, _ := rc.conditionsActive(rc.config.ConditionOperator,
+		rc.config.Conditions, rc.config.ConditionGroups)
 
 	changed := false
 
@@ -615,179 +1091,924 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 		}
 		changed = true
 
+		e := data.Event{
+			Type:    data.EventTypeRuleActive,
+			Level:   data.EventLevelInfo,
+			Message: "Rule active: " + rc.config.Description,
+		}
+
+		if !allActive {
+			e.Type = data.EventTypeRuleInactive
+			e.Message = "Rule inactive: " + rc.config.Description
+		}
+
+		err = SendEvent(rc.nc, rc.config.ID, e)
+		if err != nil {
+			log.Println("Rule error sending event:", err)
+		}
+
 		rc.config.Active = allActive
 	}
 
-	return allActive, changed, nil
+	return allActive, changed
 }
 
-// ruleRunActions runs rule actions
-func (rc *RuleClient) ruleRunActions(actions []Action, triggerNodeID string) error {
-	for i, a := range actions {
-		if a.Disabled {
+// setConditionActive updates the condition active state and point
+func (rc *RuleClient) setConditionActive(c *Condition, active bool) {
+	if active == c.Active {
+		return
+	}
+
+	p := data.Point{
+		Type:  data.PointTypeActive,
+		Time:  time.Now(),
+		Value: data.BoolToFloat(active),
+	}
+
+	err := rc.sendPoint(c.ID, p)
+	if err != nil {
+		log.Println("Rule error sending point:", err)
+	}
+
+	c.Active = active
+}
+
+// conditionState returns the state for a condition, creating it if necessary
+func (rc *RuleClient) conditionState(c Condition, now time.Time) *conditionState {
+	st, ok := rc.conditionStates[c.ID]
+	if !ok {
+		st = &conditionState{active: c.Active, changed: now,
+			compared: c.Active != c.Invert, updated: now}
+		rc.conditionStates[c.ID] = st
+	}
+
+	return st
+}
+
+// regex returns the compiled regex for a text condition. The regex is only
+// compiled when the condition value changes.
+func (st *conditionState) regex(c Condition) (*regexp.Regexp, error) {
+	if st.re != nil && st.reText == c.ValueText {
+		return st.re, nil
+	}
+
+	re, err := regexp.Compile(c.ValueText)
+	if err != nil {
+		st.re = nil
+		return nil, err
+	}
+
+	st.re, st.reText = re, c.ValueText
+	return re, nil
+}
+
+// windowActive adds a point to the window for a node, removes points that
+// are older than the window length, and compares the result of the window
+// function to the condition value for each node window. p may be nil to
+// only expire old points. Returns true if any node window matches.
+func (st *conditionState) windowActive(c Condition, nodeID string, p *data.Point,
+	now time.Time) (bool, error) {
+	if c.Window <= 0 {
+		return false, errors.New("point window condition window must be set")
+	}
+
+	windowLen := time.Duration(c.Window * float64(time.Minute))
+
+	if st.windows == nil {
+		st.windows = make(map[string]*pointWindow)
+	}
+
+	if p != nil {
+		w, ok := st.windows[nodeID]
+		if !ok {
+			w = &pointWindow{window: data.NewPointWindow(windowLen)}
+			st.windows[nodeID] = w
+		}
+
+		pt := *p
+		if pt.Time.IsZero() {
+			pt.Time = now
+		}
+
+		w.window.SetWindowLen(windowLen)
+		w.window.Add(pt)
+	}
+
+	active := false
+
+	for id, w := range st.windows {
+		w.window.SetWindowLen(windowLen)
+		w.window.Prune(now)
+
+		if w.window.Len() == 0 {
+			delete(st.windows, id)
 			continue
 		}
 
-		errorActive := false
+		var v float64
+
+		switch c.WindowFunction {
+		case data.PointValueDelta:
+			v = w.window.Delta()
+		case data.PointValueSlope:
+			v = w.window.Slope()
+		case data.PointValueMin:
+			v = w.window.Min()
+		case data.PointValueMax:
+			v = w.window.Max()
+		case data.PointValueMean:
+			v = w.window.Mean()
+		default:
+			return false, fmt.Errorf("unknown window function: %v", c.WindowFunction)
+		}
 
-		processError := func(err error) {
-			errorActive = true
-			errS := err.Error()
-			if a.Error != errS {
-				p := data.Point{
-					Type: data.PointTypeError,
-					Time: time.Now(),
-					Text: errS,
+		var err error
+		w.compared, err = numberCompare(c, v, w.compared)
+		if err != nil {
+			return false, err
+		}
+
+		active = active || w.compared
+	}
+
+	return active, nil
+}
+
+// windowExpires returns the next time a point leaves one of the condition
+// windows
+func (st *conditionState) windowExpires() (time.Time, bool) {
+	var ret time.Time
+	found := false
+
+	for _, w := range st.windows {
+		t, ok := w.window.Expires()
+		if ok && (!found || t.Before(ret)) {
+			ret = t
+			found = true
+		}
+	}
+
+	return ret, found
+}
+
+// stale returns true if no matching point has been received for the condition
+// timeout
+func (st *conditionState) stale(c Condition, now time.Time) (bool, error) {
+	if c.NodeID == "" {
+		return false, errors.New("stale condition node ID must be set")
+	}
+
+	if c.Timeout <= 0 {
+		return false, errors.New("stale condition timeout must be set")
+	}
+
+	return now.Sub(st.updated) >= c.staleTimeout(), nil
+}
+
+func (c Condition) staleTimeout() time.Duration {
+	return time.Duration(c.Timeout * float64(time.Minute))
+}
+
+// initStaleConditions initializes the last update time for stale conditions
+// from the timestamps of the points stored in the watched node. Conditions are
+// only initialized when first seen or when the watched node, point, or timeout
+// changes.
+func (rc *RuleClient) initStaleConditions() {
+	now := time.Now()
+
+	for _, c := range rc.conditions() {
+		if c.ConditionType != data.PointValueStale || c.NodeID == "" {
+			continue
+		}
+
+		_, existed := rc.conditionStates[c.ID]
+		st := rc.conditionState(*c, now)
+
+		config := fmt.Sprintf("%v:%v:%v:%v", c.NodeID, c.PointType, c.PointKey, c.Timeout)
+		if existed && st.staleConfig == config {
+			continue
+		}
+
+		nodes, err := GetNodes(rc.nc, "all", c.NodeID, "", false)
+		if err != nil {
+			log.Println("Rule error getting stale condition node:", err)
+			continue
+		}
+
+		st.staleConfig = config
+
+		var updated time.Time
+		for _, n := range nodes {
+			for _, p := range n.Points {
+				if c.PointKey != "" && c.PointKey != p.Key {
+					continue
 				}
 
-				log.Printf("Rule action error %v:%v:%v\n", rc.config.Description, a.Description, err)
-				err := rc.sendPoint(a.ID, p)
-				if err != nil {
-					log.Println("Rule error sending point:", err)
-				} else {
-					actions[i].Error = errS
+				if c.PointType != "" && c.PointType != p.Type {
+					continue
+				}
+
+				if p.Time.After(updated) {
+					updated = p.Time
 				}
 			}
-			rc.processError(errS)
 		}
 
-		switch a.Action {
-		case data.PointValueSetValue:
-			if a.NodeID == "" {
-				processError(fmt.Errorf("Error, node action nodeID must be set"))
-				break
-			}
+		if updated.IsZero() {
+			// no points yet, so the timeout starts now
+			continue
+		}
 
-			if a.PointType == "" {
-				processError(fmt.Errorf("Error, node action point type must be set"))
-				break
+		if updated.After(now) {
+			updated = now
+		}
+
+		if !existed || updated.After(st.updated) {
+			st.updated = updated
+		}
+	}
+
+	rc.armConditionTimer(now)
+}
+
+// numberCompare compares a point value to the condition value(s). prev
+// is the previous result of the comparison and is used to apply hysteresis.
+func numberCompare(c Condition, v float64, prev bool) (bool, error) {
+	h := math.Abs(c.Hysteresis)
+	if !prev {
+		h = 0
+	}
+
+	low, high := math.Min(c.Value, c.Value2), math.Max(c.Value, c.Value2)
+
+	switch c.Operator {
+	case data.PointValueGreaterThan:
+		return v > c.Value-h, nil
+	case data.PointValueGreaterThanOrEqual:
+		return v >= c.Value-h, nil
+	case data.PointValueLessThan:
+		return v < c.Value+h, nil
+	case data.PointValueLessThanOrEqual:
+		return v <= c.Value+h, nil
+	case data.PointValueEqual:
+		return v == c.Value, nil
+	case data.PointValueNotEqual:
+		return v != c.Value, nil
+	case data.PointValueBetween:
+		return v >= low-h && v <= high+h, nil
+	case data.PointValueOutside:
+		return v < low+h || v > high-h, nil
+	default:
+		return false, fmt.Errorf("unknown number operator: %v", c.Operator)
+	}
+}
+
+// conditionDelay records the raw condition state and returns the state
+// the condition should be in after applying the MinActive and MinInactive
+// delays.
+func (rc *RuleClient) conditionDelay(c Condition, raw bool, now time.Time) bool {
+	st := rc.conditionState(c, now)
+
+	if raw != st.active {
+		st.active = raw
+		st.changed = now
+	}
+
+	return st.delayed(c, now)
+}
+
+// delayed returns the condition state after applying the min active/inactive
+// delays to the raw state
+func (st *conditionState) delayed(c Condition, now time.Time) bool {
+	if st.active == c.Active {
+		return c.Active
+	}
+
+	if now.Sub(st.changed) >= st.delay(c) {
+		return st.active
+	}
+
+	return c.Active
+}
+
+// delay returns how long the raw state must be stable before the condition
+// transitions to it
+func (st *conditionState) delay(c Condition) time.Duration {
+	minutes := c.MinInactive
+	if st.active {
+		minutes = c.MinActive
+	}
+
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+// armConditionTimer sets the condition timer to expire when the next
+// pending condition delay expires
+func (rc *RuleClient) armConditionTimer(now time.Time) {
+	var next time.Duration
+	pending := false
+
+	for _, c := range rc.conditions() {
+		st, ok := rc.conditionStates[c.ID]
+		if !ok {
+			continue
+		}
+
+		if c.ConditionType == data.PointValueStale && c.Timeout > 0 && !c.Disabled {
+			remaining := st.updated.Add(c.staleTimeout()).Sub(now)
+			if remaining > 0 && (!pending || remaining < next) {
+				next = remaining
+				pending = true
 			}
+		}
 
-			p := data.Point{
-				Time:   time.Now(),
-				Type:   a.PointType,
-				Key:    a.PointKey,
-				Value:  a.Value,
-				Text:   a.ValueText,
-				Origin: a.ID,
+		if c.ConditionType == data.PointValuePointWindow && !c.Disabled {
+			if t, ok := st.windowExpires(); ok {
+				remaining := t.Sub(now)
+				if !pending || remaining < next {
+					next = remaining
+					pending = true
+				}
 			}
+		}
+
+		if st.active == c.Active {
+			continue
+		}
 
-			err := rc.sendPoint(a.NodeID, p)
+		remaining := st.changed.Add(st.delay(*c)).Sub(now)
+		if !pending || remaining < next {
+			next = remaining
+			pending = true
+		}
+	}
+
+	rc.conditionTimer.Stop()
+
+	if pending {
+		if next < 0 {
+			next = 0
+		}
+		rc.conditionTimer.Reset(next)
+	}
+}
+
+// ruleProcessConditionTimers transitions conditions whose min active/inactive
+// delay has expired and evaluates stale and point window conditions. Returns rule active state
+// and if it changed.
+func (rc *RuleClient) ruleProcessConditionTimers(now time.Time) (bool, bool) {
+	for _, c := range rc.conditions() {
+		st, ok := rc.conditionStates[c.ID]
+		if !ok {
+			continue
+		}
+
+		if c.ConditionType == data.PointValueStale {
+			stale, err := st.stale(*c, now)
 			if err != nil {
-				log.Println("Error sending rule action point:", err)
+				// error is reported when points are processed
+				continue
 			}
-		case data.PointValueNotify:
-			// get node that fired the rule
-			nodes, err := GetNodes(rc.nc, "none", triggerNodeID, "", false)
+
+			if c.Invert {
+				stale = !stale
+			}
+
+			rc.setConditionActive(c, rc.conditionDelay(*c, stale, now))
+			continue
+		}
+
+		if c.ConditionType == data.PointValuePointWindow {
+			// points that leave the window change the result
+			active, err := st.windowActive(*c, "", nil, now)
 			if err != nil {
-				processError(err)
-				break
+				continue
 			}
 
-			if len(nodes) < 1 {
-				processError(fmt.Errorf("trigger node not found"))
-				break
+			if c.Invert {
+				active = !active
 			}
 
-			triggerNode := nodes[0]
+			rc.setConditionActive(c, rc.conditionDelay(*c, active, now))
+			continue
+		}
+
+		rc.setConditionActive(c, st.delayed(*c, now))
+	}
 
-			triggerNodeDesc := triggerNode.Desc()
+	rc.armConditionTimer(now)
 
-			n := data.Notification{
-				ID:         uuid.New().String(),
-				SourceNode: a.NodeID,
-				Message:    rc.config.Description + " fired at " + triggerNodeDesc,
-			}
+	return rc.ruleUpdateActive()
+}
 
-			// TODO this notify code needs to be reworked
-			d, err := n.ToPb()
+// conditionsActive combines the active state of conditions and condition
+// groups using the and/or operator. Disabled conditions and groups are
+// ignored. The 2nd return value is false if there are no enabled
+// conditions, in which case the result is always inactive. Group active
+// points are updated as needed.
+func (rc *RuleClient) conditionsActive(op string, conds []Condition,
+	groups []ConditionGroup) (bool, bool) {
+	var states []bool
+
+	for _, c := range conds {
+		if !c.Disabled {
+			states = append(states, c.Active)
+		}
+	}
 
-			if err != nil {
-				return err
-			}
+	for i, g := range groups {
+		active, ok := rc.conditionsActive(g.ConditionOperator,
+			g.Conditions, g.ConditionGroups)
 
-			err = rc.nc.Publish("node."+rc.config.ID+".not", d)
+		if ok && g.Invert {
+			active = !active
+		}
 
-			if err != nil {
-				return err
+		if active != g.Active {
+			p := data.Point{
+				Type:  data.PointTypeActive,
+				Time:  time.Now(),
+				Value: data.BoolToFloat(active),
 			}
-		case data.PointValuePlayAudio:
-			f, err := os.Open(a.PointFilePath)
+
+			err := rc.sendPoint(g.ID, p)
 			if err != nil {
-				log.Fatal(err)
+				log.Println("Rule error sending point:", err)
 			}
-			defer f.Close()
 
-			d := wav.NewDecoder(f)
-			d.ReadInfo()
+			groups[i].Active = active
+		}
 
-			format := d.Format()
+		if ok && !g.Disabled {
+			states = append(states, active)
+		}
+	}
 
-			if format.SampleRate < 8000 {
-				log.Println("Rule action: invalid wave file sample rate:", format.SampleRate)
-				continue
+	if len(states) == 0 {
+		return false, false
+	}
+
+	switch op {
+	case data.PointValueOr:
+		for _, s := range states {
+			if s {
+				return true, true
 			}
+		}
+		return false, true
+	default:
+		for _, s := range states {
+			if !s {
+				return false, true
+			}
+		}
+		return true, true
+	}
+}
 
-			channelNum := strconv.Itoa(a.PointChannel)
-			sampleRate := strconv.Itoa(format.SampleRate)
+// ruleRunActions starts rule actions. Actions are run in order of their
+// index point. Actions with a delay or repeat interval are scheduled and run
+// from the action timer.
+func (rc *RuleClient) ruleRunActions(actions []Action, triggerNodeID string) error {
+	now := time.Now()
 
-			go func() {
-				stderr, err := exec.Command("speaker-test", "-D"+a.PointDevice, "-twav", "-w"+a.PointFilePath, "-c5", "-s"+channelNum, "-r"+sampleRate).CombinedOutput()
-				if err != nil {
-					log.Println("Play audio error:", err)
-					log.Printf("Audio stderr: %s\n", stderr)
-				}
-			}()
-		default:
-			processError(fmt.Errorf("Uknown rule action: %v", a.Action))
+	defer rc.armActionTimer()
+
+	for _, i := range actionOrder(actions) {
+		a := actions[i]
+		if a.Disabled {
+			rc.cancelAction(actions, i)
+			continue
 		}
 
-		p := data.Point{
-			Type:  data.PointTypeActive,
-			Value: 1,
+		if _, ok := rc.actionStates[a.ID]; ok {
+			// action is already pending or repeating
+			continue
 		}
-		err := rc.sendPoint(a.ID, p)
+
+		if a.Active && (a.Action == data.PointValueHTTP ||
+			a.Action == data.PointValueExec ||
+			a.Action == data.PointValueNotify ||
+			a.Action == data.PointValueAlarm) {
+			// requests, commands, notifications, and alarms are only run
+			// when the action goes active, not every time the rule is
+			// evaluated
+			continue
+		}
+
+		delay := secondsToDuration(a.Delay)
+		repeat := secondsToDuration(a.Repeat)
+
+		if delay > 0 {
+			rc.actionStates[a.ID] = &actionState{
+				next:          now.Add(delay),
+				triggerNodeID: triggerNodeID,
+			}
+			rc.setActionActive(actions, i, true)
+			continue
+		}
+
+		err := rc.ruleRunAction(actions, i, triggerNodeID, false)
 		if err != nil {
-			log.Println("Error sending rule action point:", err)
+			return err
 		}
 
-		actions[i].Active = true
+		if repeat > 0 {
+			st := rc.actionState(a.ID, triggerNodeID)
+			st.next = now.Add(repeat)
+			st.ran = true
+		}
+	}
+
+	return nil
+}
+
+// ruleRunAction runs a single rule action. Repeat is set if the action has
+// already been run for the current rule state.
+func (rc *RuleClient) ruleRunAction(actions []Action, i int, triggerNodeID string, repeat bool) error {
+	a := actions[i]
+
+	errorActive := false
+	// background actions report errors when they complete
+	background := false
 
-		if !errorActive && a.Error != "" {
+	processError := func(err error) {
+		errorActive = true
+		errS := err.Error()
+		if a.Error != errS {
 			p := data.Point{
 				Type: data.PointTypeError,
 				Time: time.Now(),
-				Text: "",
+				Text: errS,
 			}
 
+			log.Printf("Rule action error %v:%v:%v\n", rc.config.Description, a.Description, err)
 			err := rc.sendPoint(a.ID, p)
 			if err != nil {
 				log.Println("Rule error sending point:", err)
 			} else {
-				actions[i].Error = ""
+				actions[i].Error = errS
 			}
-			rc.processError("")
+		}
+		rc.processError(errS)
+	}
+
+	switch a.Action {
+	case data.PointValueSetValue:
+		if a.NodeID == "" {
+			processError(fmt.Errorf("Error, node action nodeID must be set"))
+			break
 		}
 
+		if a.PointType == "" {
+			processError(fmt.Errorf("Error, node action point type must be set"))
+			break
+		}
+
+		p := data.Point{
+			Time:   time.Now(),
+			Type:   a.PointType,
+			Key:    a.PointKey,
+			Value:  a.Value,
+			Text:   a.ValueText,
+			Origin: a.ID,
+		}
+
+		err := rc.sendPoint(a.NodeID, p)
+		if err != nil {
+			log.Println("Error sending rule action point:", err)
+		}
+	case data.PointValueNotify:
+		err := rc.notify(actions, i, triggerNodeID, repeat)
+		if err != nil {
+			processError(err)
+		}
+	case data.PointValueAlarm:
+		if a.NodeID == "" {
+			processError(errors.New("Error, alarm action nodeID must be set"))
+			break
+		}
+
+		err := rc.setAlarm(a, triggerNodeID, true)
+		if err != nil {
+			processError(err)
+		}
+	case data.PointValuePlayAudio:
+		f, err := os.Open(a.PointFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		d := wav.NewDecoder(f)
+		d.ReadInfo()
+
+		format := d.Format()
+
+		if format.SampleRate < 8000 {
+			log.Println("Rule action: invalid wave file sample rate:", format.SampleRate)
+			return nil
+		}
+
+		channelNum := strconv.Itoa(a.PointChannel)
+		sampleRate := strconv.Itoa(format.SampleRate)
+
+		go func() {
+			stderr, err := exec.Command("speaker-test", "-D"+a.PointDevice, "-twav", "-w"+a.PointFilePath, "-c5", "-s"+channelNum, "-r"+sampleRate).CombinedOutput()
+			if err != nil {
+				log.Println("Play audio error:", err)
+				log.Printf("Audio stderr: %s\n", stderr)
+			}
+		}()
+	case data.PointValueHTTP:
+		if a.URI == "" {
+			processError(errors.New("Error, http action URI must be set"))
+			break
+		}
+
+		d, err := rc.actionTemplateData(triggerNodeID)
+		if err != nil {
+			processError(err)
+			break
+		}
+
+		background = true
+		go rc.httpAction(a, d)
+	case data.PointValueExec:
+		if a.Command == "" {
+			processError(errors.New("Error, exec action command must be set"))
+			break
+		}
+
+		d, err := rc.actionTemplateData(triggerNodeID)
+		if err != nil {
+			processError(err)
+			break
+		}
+
+		background = true
+		go rc.execAction(a, d)
+	default:
+		processError(fmt.Errorf("Uknown rule action: %v", a.Action))
 	}
+
+	p := data.Point{
+		Type:  data.PointTypeActive,
+		Value: 1,
+	}
+	err := rc.sendPoint(a.ID, p)
+	if err != nil {
+		log.Println("Error sending rule action point:", err)
+	}
+
+	actions[i].Active = true
+
+	if !errorActive && !background && a.Error != "" {
+		p := data.Point{
+			Type: data.PointTypeError,
+			Time: time.Now(),
+			Text: "",
+		}
+
+		err := rc.sendPoint(a.ID, p)
+		if err != nil {
+			log.Println("Rule error sending point:", err)
+		} else {
+			actions[i].Error = ""
+		}
+		rc.processError("")
+	}
+
 	return nil
 }
 
+// ruleProcessActionTimer runs actions whose delay or repeat interval
+// has expired
+func (rc *RuleClient) ruleProcessActionTimer(now time.Time) {
+	for _, actions := range [][]Action{rc.config.Actions, rc.config.ActionsInactive} {
+		for _, i := range actionOrder(actions) {
+			a := actions[i]
+			st, ok := rc.actionStates[a.ID]
+			if !ok || st.nextTime().After(now) {
+				continue
+			}
+
+			if a.Disabled {
+				rc.cancelAction(actions, i)
+				continue
+			}
+
+			if !st.next.IsZero() && !st.next.After(now) {
+				err := rc.ruleRunAction(actions, i, st.triggerNodeID, st.ran)
+				if err != nil {
+					log.Println("Error running rule action:", err)
+				}
+				st.ran = true
+
+				repeat := secondsToDuration(a.Repeat)
+				if repeat > 0 {
+					st.next = st.next.Add(repeat)
+					if st.next.Before(now) {
+						st.next = now.Add(repeat)
+					}
+				} else {
+					st.next = time.Time{}
+				}
+			}
+
+			if !st.escalate.IsZero() && !st.escalate.After(now) {
+				rc.escalateNotification(actions, i, st, now)
+			}
+
+			// the state is kept after the action has run so the action
+			// is not scheduled again if the rule is re-evaluated. It
+			// is removed when the rule changes state.
+		}
+	}
+
+	rc.armActionTimer()
+}
+
+// armActionTimer sets the action timer to fire for the next pending action
+func (rc *RuleClient) armActionTimer() {
+	if !rc.actionTimer.Stop() {
+		select {
+		case <-rc.actionTimer.C:
+		default:
+		}
+	}
+
+	var next time.Time
+	for _, st := range rc.actionStates {
+		t := st.nextTime()
+		if t.IsZero() {
+			continue
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	if next.IsZero() {
+		return
+	}
+
+	rc.actionTimer.Reset(time.Until(next))
+}
+
+// actionState returns the state for an action, creating it if needed
+func (rc *RuleClient) actionState(id, triggerNodeID string) *actionState {
+	st, ok := rc.actionStates[id]
+	if !ok {
+		st = &actionState{triggerNodeID: triggerNodeID}
+		rc.actionStates[id] = st
+	}
+	return st
+}
+
+// cancelAction cancels any pending runs of an action and clears its
+// active state
+func (rc *RuleClient) cancelAction(actions []Action, i int) {
+	delete(rc.actionStates, actions[i].ID)
+	if actions[i].Active {
+		rc.setActionActive(actions, i, false)
+	}
+}
+
+func (rc *RuleClient) setActionActive(actions []Action, i int, active bool) {
+	p := data.Point{
+		Type:  data.PointTypeActive,
+		Value: data.BoolToFloat(active),
+	}
+	err := rc.sendPoint(actions[i].ID, p)
+	if err != nil {
+		log.Println("Error sending rule action point:", err)
+	}
+
+	actions[i].Active = active
+}
+
+// actionOrder returns the indexes of actions sorted by their index point
+func actionOrder(actions []Action) []int {
+	ret := make([]int, len(actions))
+	for i := range ret {
+		ret[i] = i
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return actions[ret[i]].Index < actions[ret[j]].Index
+	})
+
+	return ret
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// actionTemplateData is passed to action templates (http body, etc)
+type actionTemplateData struct {
+	RuleID          string
+	RuleDescription string
+	RuleActive      bool
+	// TriggerNode is the node that caused the rule to run. Points from the
+	// trigger node can be accessed in templates with the value and text
+	// functions, ex: {{value .TriggerNode.Points "temp" ""}}
+	TriggerNode data.NodeEdge
+	Time        time.Time
+}
+
+func (rc *RuleClient) actionTemplateData(triggerNodeID string) (actionTemplateData, error) {
+	ret := actionTemplateData{
+		RuleID:          rc.config.ID,
+		RuleDescription: rc.config.Description,
+		RuleActive:      rc.config.Active,
+		Time:            time.Now(),
+	}
+
+	if triggerNodeID == "" {
+		return ret, nil
+	}
+
+	nodes, err := GetNodes(rc.nc, "all", triggerNodeID, "", false)
+	if err != nil {
+		return ret, err
+	}
+
+	if len(nodes) > 0 {
+		ret.TriggerNode = nodes[0]
+	}
+
+	return ret, nil
+}
+
+// actionTemplateFuncs are functions that can be used in action templates
+var actionTemplateFuncs = template.FuncMap{
+	"value": func(pts data.Points, typ, key string) float64 {
+		v, _ := pts.Value(typ, key)
+		return v
+	},
+	"text": func(pts data.Points, typ, key string) string {
+		v, _ := pts.Text(typ, key)
+		return v
+	},
+	"desc": func(n data.NodeEdge) string {
+		return n.Desc()
+	},
+	"json": func(v any) (string, error) {
+		d, err := json.Marshal(v)
+		return string(d), err
+	},
+}
+
+// executeActionTemplate renders an action template
+func executeActionTemplate(name, tmpl string, d actionTemplateData) (string, error) {
+	t, err := template.New(name).Funcs(actionTemplateFuncs).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing %v template: %w", name, err)
+	}
+
+	var b strings.Builder
+	err = t.Execute(&b, d)
+	if err != nil {
+		return "", fmt.Errorf("Error executing %v template: %w", name, err)
+	}
+
+	return b.String(), nil
+}
+
+// setAlarm sets the alarm condition of the alarm node an action raises.
+// The point text is set to the node that triggered the rule.
+func (rc *RuleClient) setAlarm(a Action, triggerNodeID string, active bool) error {
+	return rc.sendPoint(a.NodeID, data.Point{
+		Time:  time.Now(),
+		Type:  data.PointTypeActive,
+		Value: data.BoolToFloat(active),
+		Text:  triggerNodeID,
+	})
+}
+
 func (rc *RuleClient) ruleInactiveActions(actions []Action) error {
 	for i, a := range actions {
+		// cancel pending actions, even if disabled
+		delete(rc.actionStates, a.ID)
+
 		if a.Disabled {
 			continue
 		}
 
-		p := data.Point{
-			Type:  data.PointTypeActive,
-			Value: 0,
-		}
-		err := rc.sendPoint(a.ID, p)
-		if err != nil {
-			log.Println("Error sending rule action point:", err)
+		if a.Action == data.PointValueAlarm && a.Active && a.NodeID != "" {
+			// clear alarms raised by this action
+			err := rc.setAlarm(a, "", false)
+			if err != nil {
+				log.Println("Error clearing alarm:", err)
+			}
 		}
-		actions[i].Active = false
+
+		rc.setActionActive(actions, i, false)
 	}
+
+	rc.armActionTimer()
+
 	return nil
 }
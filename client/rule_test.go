@@ -2,6 +2,12 @@ package client_test
 
 import (
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -364,3 +370,986 @@ func TestRuleActionPointKey(t *testing.T) {
 
 	r.checkVout(0, "should be low", "1")
 }
+
+/*
+Test text operators on point value conditions.
+*/
+func TestRuleTextConditions(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	// switch the condition over to watch the text of the sysState point
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypePointType, Text: data.PointTypeSysState})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueType, Text: data.PointValueText})
+
+	tests := []struct {
+		operator  string
+		valueText string
+		text      string
+		expected  float64
+	}{
+		{data.PointValueEqual, "offline", "offline", 1},
+		{data.PointValueEqual, "offline", "online", 0},
+		{data.PointValueNotEqual, "offline", "offline", 0},
+		{data.PointValueNotEqual, "offline", "online", 1},
+		{data.PointValueContains, "line", "offline", 1},
+		{data.PointValueContains, "power", "online", 0},
+		{data.PointValueStartsWith, "off", "offline", 1},
+		{data.PointValueStartsWith, "off", "online", 0},
+		{data.PointValueEndsWith, "Off", "powerOff", 1},
+		{data.PointValueEndsWith, "Off", "online", 0},
+		{data.PointValueRegex, "^(off|on)line$", "online", 1},
+		{data.PointValueRegex, "^(off|on)line$", "powerOff", 0},
+	}
+
+	for _, test := range tests {
+		r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeOperator, Text: test.operator})
+		r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueText, Text: test.valueText})
+		r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeSysState, Text: test.text})
+		r.checkVout(test.expected, fmt.Sprintf("%v %v %v", test.text, test.operator,
+			test.valueText), "0")
+	}
+}
+
+/*
+An invalid regex should set the condition error and leave the rule inactive.
+*/
+func TestRuleTextConditionInvalidRegex(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	cGet, cStop, err := client.NodeWatcher[client.Condition](r.nc, r.c.ID, r.c.Parent)
+	if err != nil {
+		t.Fatal("Error setting up condition watcher: ", err)
+	}
+	defer cStop()
+
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypePointType, Text: data.PointTypeSysState})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueType, Text: data.PointValueText})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeOperator, Text: data.PointValueRegex})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueText, Text: "(offline"})
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeSysState, Text: "offline"})
+	r.checkVout(0, "invalid regex", "0")
+
+	start := time.Now()
+	for cGet().Error == "" {
+		if time.Since(start) > time.Second {
+			t.Fatal("condition error not set for invalid regex")
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+
+	// fixing the pattern should clear the error and activate the rule
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueText, Text: "^off"})
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeSysState, Text: "offline"})
+	r.checkVout(1, "valid regex", "0")
+
+	start = time.Now()
+	for cGet().Error != "" {
+		if time.Since(start) > time.Second {
+			t.Fatal("condition error not cleared after fixing regex")
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+}
+
+/*
+With the or operator, the rule is active if any condition is active.
+*/
+func TestRuleConditionOperatorOr(t *testing.T) {
+	r, err := setupRuleTest(t, 2)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	r.sendPoint(r.r.ID, data.Point{Type: data.PointTypeConditionOperator, Text: data.PointValueOr})
+
+	r.checkVout(0, "initial value", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "1st active", "0")
+
+	r.sendPoint(r.vin2.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "both active", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(1, "2nd active", "0")
+
+	r.sendPoint(r.vin2.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(0, "none active", "0")
+}
+
+/*
+An inverted condition is active when the comparison is false.
+*/
+func TestRuleConditionInvert(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeInvert, Value: 1})
+
+	r.checkVout(0, "initial value", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(1, "vin low", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(0, "vin high", "0")
+}
+
+/*
+Test a rule that is: vin AND (vin2 OR vin3)
+*/
+func TestRuleConditionGroup(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	vin2 := client.Variable{ID: "ID-varin2", Parent: r.root.ID, Description: "var in2"}
+	vin3 := client.Variable{ID: "ID-varin3", Parent: r.root.ID, Description: "var in3"}
+
+	g := client.ConditionGroup{
+		ID:                "ID-group",
+		Parent:            r.r.ID,
+		Description:       "vin2 or vin3",
+		ConditionOperator: data.PointValueOr,
+	}
+
+	c2 := client.Condition{
+		ID:            "ID-condition2",
+		Parent:        g.ID,
+		Description:   "cond vin2 high",
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueOnOff,
+		NodeID:        vin2.ID,
+		Value:         1,
+	}
+
+	c3 := c2
+	c3.ID = "ID-condition3"
+	c3.Description = "cond vin3 high"
+	c3.NodeID = vin3.ID
+
+	for _, v := range []client.Variable{vin2, vin3} {
+		err = client.SendNodeType(r.nc, v, "test")
+		if err != nil {
+			t.Fatal("Error sending variable node: ", err)
+		}
+	}
+
+	err = client.SendNodeType(r.nc, g, "test")
+	if err != nil {
+		t.Fatal("Error sending group node: ", err)
+	}
+
+	for _, c := range []client.Condition{c2, c3} {
+		err = client.SendNodeType(r.nc, c, "test")
+		if err != nil {
+			t.Fatal("Error sending condition node: ", err)
+		}
+	}
+
+	// wait for rule to restart with the new nodes
+	time.Sleep(250 * time.Millisecond)
+
+	r.checkVout(0, "initial value", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(0, "vin active, group inactive", "0")
+
+	r.sendPoint(vin2.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "vin and vin2 active", "0")
+
+	r.sendPoint(vin2.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(0, "vin2 cleared", "0")
+
+	r.sendPoint(vin3.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "vin and vin3 active", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(0, "vin cleared", "0")
+
+	// invert the group: vin AND NOT (vin2 OR vin3)
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.sendPoint(g.ID, data.Point{Type: data.PointTypeInvert, Value: 1})
+	r.checkVout(0, "group inverted", "0")
+
+	r.sendPoint(vin3.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(1, "group inverted, vin2 and vin3 cleared", "0")
+
+	// disable the group, leaving only vin
+	r.sendPoint(g.ID, data.Point{Type: data.PointTypeDisabled, Value: 1})
+	r.sendPoint(vin2.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "group disabled", "0")
+}
+
+/*
+Test condition min active/inactive delays.
+*/
+func TestRuleConditionMinActive(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	// min active/inactive times are in minutes, 0.005m = 300ms
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeMinActive, Value: 0.005})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeMinInactive, Value: 0.005})
+
+	r.checkVout(0, "initial value", "0")
+
+	// a short pulse should be ignored
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	time.Sleep(100 * time.Millisecond)
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	time.Sleep(400 * time.Millisecond)
+	r.checkVout(0, "short pulse ignored", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	start := time.Now()
+	r.checkVout(1, "vin set for min active time", "0")
+	if time.Since(start) < 250*time.Millisecond {
+		t.Fatal("condition went active before min active time")
+	}
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	start = time.Now()
+	r.checkVout(0, "vin cleared for min inactive time", "0")
+	if time.Since(start) < 250*time.Millisecond {
+		t.Fatal("condition went inactive before min inactive time")
+	}
+}
+
+/*
+Test numeric operators and hysteresis
+*/
+func TestRuleConditionHysteresis(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueType, Text: data.PointValueNumber})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeOperator, Text: data.PointValueGreaterThan})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValue, Value: 10})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeHysteresis, Value: 2})
+
+	tests := []struct {
+		value    float64
+		expected float64
+	}{
+		{9, 0},
+		{11, 1},
+		{9, 1},
+		{8.5, 1},
+		{7.5, 0},
+		{9, 0},
+		{10.5, 1},
+	}
+
+	for _, test := range tests {
+		r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: test.value})
+		r.checkVout(test.expected, fmt.Sprintf("> 10 hyst 2, value: %v", test.value), "0")
+	}
+
+	// clear condition, then try < operator
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 5})
+	r.checkVout(0, "> 10 hyst 2, value: 5", "0")
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeOperator, Text: data.PointValueLessThan})
+
+	tests = []struct {
+		value    float64
+		expected float64
+	}{
+		{11, 0},
+		{9, 1},
+		{11, 1},
+		{12.5, 0},
+		{11, 0},
+	}
+
+	for _, test := range tests {
+		r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: test.value})
+		r.checkVout(test.expected, fmt.Sprintf("< 10 hyst 2, value: %v", test.value), "0")
+	}
+}
+
+/*
+Test numeric range operators
+*/
+func TestRuleConditionRange(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueType, Text: data.PointValueNumber})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValue, Value: 10})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValue2, Value: 20})
+
+	tests := []struct {
+		operator string
+		value    float64
+		expected float64
+	}{
+		{data.PointValueBetween, 15, 1},
+		{data.PointValueBetween, 25, 0},
+		{data.PointValueBetween, 10, 1},
+		{data.PointValueBetween, 5, 0},
+		{data.PointValueOutside, 5, 1},
+		{data.PointValueOutside, 15, 0},
+		{data.PointValueOutside, 25, 1},
+		{data.PointValueGreaterThanOrEqual, 10, 1},
+		{data.PointValueGreaterThanOrEqual, 9, 0},
+		{data.PointValueLessThanOrEqual, 10, 1},
+		{data.PointValueLessThanOrEqual, 11, 0},
+	}
+
+	for _, test := range tests {
+		r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeOperator, Text: test.operator})
+		r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: test.value})
+		r.checkVout(test.expected, fmt.Sprintf("%v %v", test.operator, test.value), "0")
+	}
+}
+
+/*
+Test stale condition goes active when a node stops sending points.
+*/
+func TestRuleConditionStale(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	// timeout is in minutes, 0.005m = 300ms
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeTimeout, Value: 0.005})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeConditionType, Text: data.PointValueStale})
+
+	r.checkVout(0, "initial value", "0")
+
+	// keep vin alive
+	var lastUpdate time.Time
+	for i := 0; i < 5; i++ {
+		r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: float64(i)})
+		lastUpdate = time.Now()
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	r.checkVout(0, "vin is updating", "0")
+
+	// stop updating vin and look for rule to go active
+	r.checkVout(1, "vin stale", "0")
+	if time.Since(lastUpdate) < 250*time.Millisecond {
+		t.Fatal("stale condition went active too soon")
+	}
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(0, "vin updated", "0")
+
+	r.checkVout(1, "vin stale again", "0")
+}
+
+/*
+Test point window conditions
+*/
+func TestRuleConditionPointWindow(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueType, Text: data.PointValueNumber})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeWindow, Value: 1})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeWindowFunction, Text: data.PointValueDelta})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeOperator, Text: data.PointValueGreaterThan})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValue, Value: 5})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeConditionType, Text: data.PointValuePointWindow})
+
+	r.checkVout(0, "initial value", "0")
+
+	tests := []struct {
+		value    float64
+		expected float64
+	}{
+		{10, 0},
+		{12, 0},
+		{14, 0},
+		{16, 1},
+		{13, 0},
+	}
+
+	for _, test := range tests {
+		r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: test.value})
+		r.checkVout(test.expected, fmt.Sprintf("delta > 5, value: %v", test.value), "0")
+	}
+
+	// window now contains 10, 12, 14, 16, 13
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeWindowFunction, Text: data.PointValueMean})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValue, Value: 13})
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 20})
+	r.checkVout(1, "mean > 13", "0")
+
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeWindowFunction, Text: data.PointValueMin})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValue, Value: 11})
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 20})
+	r.checkVout(0, "min > 11", "0")
+}
+
+/*
+Test point window conditions expire old points when no new points arrive, and
+keep a separate window for each node when the node ID is not set.
+*/
+func TestRuleConditionPointWindowExpire(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	// window is in minutes, 0.005m = 300ms
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValueType, Text: data.PointValueNumber})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeNodeID, Text: ""})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeWindow, Value: 0.005})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeWindowFunction, Text: data.PointValueMax})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeOperator, Text: data.PointValueGreaterThan})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeValue, Value: 5})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeConditionType, Text: data.PointValuePointWindow})
+
+	r.checkVout(0, "initial value", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 10})
+	r.checkVout(1, "max > 5", "0")
+
+	// no new points, so the point leaves the window
+	r.checkVout(0, "window expired", "0")
+
+	vin2 := client.Variable{ID: "ID-varin2", Parent: r.root.ID, Description: "var in2"}
+	err = client.SendNodeType(r.nc, vin2, "test")
+	if err != nil {
+		t.Fatal("Error sending vin2 node: ", err)
+	}
+
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeWindow, Value: 1})
+	r.sendPoint(r.c.ID, data.Point{Type: data.PointTypeWindowFunction, Text: data.PointValueDelta})
+
+	// the delta across both nodes is 10, but each node only has one point
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.sendPoint(vin2.ID, data.Point{Type: data.PointTypeValue, Value: 10})
+	r.checkVout(0, "delta of separate nodes", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 6})
+	r.checkVout(1, "delta of vin > 5", "0")
+}
+
+/*
+Test http actions
+*/
+func TestRuleActionHTTP(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	type request struct {
+		method string
+		header string
+		body   string
+	}
+
+	requests := make(chan request, 10)
+	var fail atomic.Bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests <- request{req.Method, req.Header.Get("X-Test"), string(body)}
+		if fail.Load() {
+			http.Error(w, "broken", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	a := client.Action{
+		ID:          "ID-action-http",
+		Parent:      r.r.ID,
+		Description: "action http",
+		Action:      data.PointValueHTTP,
+		URI:         srv.URL,
+		Method:      "PUT",
+		Headers:     map[string]string{"X-Test": "hi"},
+		Body:        `{{.RuleDescription}} {{value .TriggerNode.Points "value" "0"}}`,
+	}
+
+	err = client.SendNodeType(r.nc, a, "test")
+	if err != nil {
+		t.Fatal("Error sending http action: ", err)
+	}
+
+	aGet, aStop, err := client.NodeWatcher[client.Action](r.nc, a.ID, a.Parent)
+	if err != nil {
+		t.Fatal("Error setting up action watcher: ", err)
+	}
+	defer aStop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	getRequest := func() request {
+		select {
+		case req := <-requests:
+			return req
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for http request")
+		}
+		return request{}
+	}
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "vin high", "0")
+
+	req := getRequest()
+	if req.method != "PUT" {
+		t.Error("wrong method: ", req.method)
+	}
+	if req.header != "hi" {
+		t.Error("wrong header: ", req.header)
+	}
+	if req.body != "test rule 1" {
+		t.Error("wrong body: ", req.body)
+	}
+
+	// requests should only be sent when the rule goes active
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(0, "vin low", "0")
+
+	select {
+	case req := <-requests:
+		t.Fatal("request sent when rule went inactive: ", req)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// error status should set the action error
+	fail.Store(true)
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "vin high, http fail", "0")
+	getRequest()
+
+	start := time.Now()
+	for aGet().Error == "" {
+		if time.Since(start) > time.Second {
+			t.Fatal("http action error not set")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !strings.Contains(aGet().Error, "400") {
+		t.Error("unexpected action error: ", aGet().Error)
+	}
+}
+
+/*
+Test exec actions
+*/
+func TestRuleActionExec(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	a := client.Action{
+		ID:          "ID-action-exec",
+		Parent:      r.r.ID,
+		Description: "action exec",
+		Action:      data.PointValueExec,
+		Command:     sh,
+		Args: []string{"-c",
+			`echo "$SIOT_RULE_DESCRIPTION:$SIOT_TRIGGER_NODE_ID"; echo oops >&2; exit 3`},
+	}
+
+	err = client.SendNodeType(r.nc, a, "test")
+	if err != nil {
+		t.Fatal("Error sending exec action: ", err)
+	}
+
+	aGet, aStop, err := client.NodeWatcher[client.Action](r.nc, a.ID, a.Parent)
+	if err != nil {
+		t.Fatal("Error setting up action watcher: ", err)
+	}
+	defer aStop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "vin high", "0")
+
+	start := time.Now()
+	for aGet().ExitCode != 3 || aGet().Error == "" {
+		if time.Since(start) > time.Second {
+			t.Fatalf("exec action results not set: %+v", aGet())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if aGet().Stdout != "test rule:"+r.vin.ID {
+		t.Error("wrong stdout: ", aGet().Stdout)
+	}
+
+	if aGet().Stderr != "oops" {
+		t.Error("wrong stderr: ", aGet().Stderr)
+	}
+
+	// command timeout should be reported as an error
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(0, "vin low", "0")
+
+	r.sendPoint(a.ID, data.Point{Type: data.PointTypeArg, Key: "1", Text: "sleep 5"})
+	r.sendPoint(a.ID, data.Point{Type: data.PointTypeTimeout, Value: 0.1})
+	time.Sleep(50 * time.Millisecond)
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "vin high, timeout", "0")
+
+	start = time.Now()
+	for aGet().ExitCode != -1 {
+		if time.Since(start) > 3*time.Second {
+			t.Fatalf("exec action timeout not reported: %+v", aGet())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !strings.Contains(aGet().Error, "timed out") {
+		t.Error("unexpected action error: ", aGet().Error)
+	}
+}
+
+/*
+Test delayed actions and that pending actions are cancelled when the rule
+goes inactive
+*/
+func TestRuleActionDelay(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	aGet, aStop, err := client.NodeWatcher[client.Action](r.nc, r.a.ID, r.a.Parent)
+	if err != nil {
+		t.Fatal("Error setting up action watcher: ", err)
+	}
+	defer aStop()
+
+	r.sendPoint(r.a.ID, data.Point{Type: data.PointTypeDelay, Value: 0.3})
+	time.Sleep(50 * time.Millisecond)
+
+	r.checkVout(0, "initial value", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	start := time.Now()
+	time.Sleep(100 * time.Millisecond)
+
+	if !aGet().Active {
+		t.Error("pending action should be active")
+	}
+
+	r.checkVout(0, "action pending", "0")
+	r.checkVout(1, "action ran after delay", "0")
+	if time.Since(start) < 250*time.Millisecond {
+		t.Fatal("action ran too soon")
+	}
+
+	// go inactive before the delay expires, action should not run
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(0, "vin low", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	time.Sleep(100 * time.Millisecond)
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	time.Sleep(50 * time.Millisecond)
+
+	if aGet().Active {
+		t.Error("cancelled action should not be active")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	r.checkVout(0, "cancelled action did not run", "0")
+}
+
+/*
+Test a delayed action only runs once if the rule is re-evaluated while it
+stays active
+*/
+func TestRuleActionDelayReevaluate(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	r.sendPoint(r.a.ID, data.Point{Type: data.PointTypeDelay, Value: 0.2})
+	time.Sleep(50 * time.Millisecond)
+
+	r.checkVout(0, "initial value", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	r.checkVout(1, "action ran after delay", "0")
+
+	// clear vout, then change the rule config so the active rule is
+	// re-evaluated
+	r.sendPoint(r.vout.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	r.checkVout(0, "vout cleared", "0")
+
+	r.sendPoint(r.r.ID, data.Point{Type: data.PointTypeDescription, Text: "test rule 2"})
+	time.Sleep(300 * time.Millisecond)
+	r.checkVout(0, "delayed action did not run again", "0")
+}
+
+/*
+Test a sequence of repeating actions: set vout on, wait, set vout off, repeat
+*/
+func TestRuleActionRepeatSequence(t *testing.T) {
+	r, err := setupRuleTest(t, 1)
+	if err != nil {
+		t.Fatal("Rule test setup failed: ", err)
+	}
+
+	defer r.stop()
+	defer r.voutStop()
+
+	// disable the inactive action so it does not interfere with
+	// the sequence
+	r.sendPoint(r.a2.ID, data.Point{Type: data.PointTypeDisabled, Value: 1})
+
+	aOff := client.Action{
+		ID:          "ID-action-off",
+		Parent:      r.r.ID,
+		Description: "action off",
+		Action:      data.PointValueSetValue,
+		PointType:   data.PointTypeValue,
+		NodeID:      r.vout.ID,
+		Value:       0,
+		Index:       1,
+		Delay:       0.2,
+		Repeat:      0.4,
+	}
+
+	err = client.SendNodeType(r.nc, aOff, "test")
+	if err != nil {
+		t.Fatal("Error sending action: ", err)
+	}
+
+	r.sendPoint(r.a.ID, data.Point{Type: data.PointTypeRepeat, Value: 0.4})
+	time.Sleep(100 * time.Millisecond)
+
+	r.checkVout(0, "initial value", "0")
+
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+
+	for i := 0; i < 2; i++ {
+		r.checkVout(1, fmt.Sprintf("sequence on %v", i), "0")
+		r.checkVout(0, fmt.Sprintf("sequence off %v", i), "0")
+	}
+
+	// after going inactive the sequence should stop
+	r.sendPoint(r.vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	time.Sleep(50 * time.Millisecond)
+	r.sendPoint(r.vout.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	time.Sleep(500 * time.Millisecond)
+	r.checkVout(0, "sequence stopped", "0")
+}
+
+/*
+Test notification escalation, repeat, and acknowledgement
+*/
+func TestRuleNotifyEscalation(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	srv, msgs := fakeTwilio(t)
+	defer srv.Close()
+
+	// users in the plant group are notified first, then users
+	// in the root node
+	group := client.Group{ID: "ID-plant", Parent: root.ID, Description: "plant"}
+	user1 := client.User{ID: "ID-user1", Parent: group.ID, FirstName: "Joe", Phone: "+1"}
+	user2 := client.User{ID: "ID-user2", Parent: root.ID, FirstName: "Jane", Phone: "+2"}
+	vin := client.Variable{ID: "ID-varin", Parent: group.ID, Description: "var in"}
+	svc := client.MsgService{
+		ID:        "ID-twilio",
+		Parent:    root.ID,
+		Service:   data.PointValueTwilio,
+		SID:       "sid123",
+		AuthToken: "token",
+		From:      "+15555550000",
+		URI:       srv.URL,
+	}
+	rule := client.Rule{ID: "ID-rule", Parent: group.ID, Description: "rule"}
+	cond := client.Condition{
+		ID:            "ID-condition",
+		Parent:        rule.ID,
+		ConditionType: data.PointValuePointValue,
+		PointType:     data.PointTypeValue,
+		ValueType:     data.PointValueOnOff,
+		NodeID:        vin.ID,
+		Operator:      data.PointValueEqual,
+		Value:         1,
+	}
+	// escalation is in minutes, 0.005m = 300ms
+	action := client.Action{
+		ID:         "ID-action",
+		Parent:     rule.ID,
+		Action:     data.PointValueNotify,
+		Escalation: 0.005,
+	}
+
+	for _, n := range []any{group, user1, user2, vin, svc, rule, cond, action} {
+		err = client.SendNodeType(nc, n, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	aGet, aStop, err := client.NodeWatcher[client.Action](nc, action.ID, action.Parent)
+	if err != nil {
+		t.Fatal("Error setting up action watcher: ", err)
+	}
+	defer aStop()
+
+	time.Sleep(250 * time.Millisecond)
+
+	sendPoint := func(id string, p data.Point) {
+		p.Origin = "test"
+		err := client.SendNodePoint(nc, id, p, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	getMsg := func(to, msg string) {
+		select {
+		case m := <-msgs:
+			if m.to != to {
+				t.Fatalf("%v: expected message to %v, got: %+v", msg, to, m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: timeout waiting for message", msg)
+		}
+	}
+
+	noMsg := func(wait time.Duration, msg string) {
+		select {
+		case m := <-msgs:
+			t.Fatalf("%v: unexpected message: %+v", msg, m)
+		case <-time.After(wait):
+		}
+	}
+
+	waitAction := func(f func(a client.Action) bool, msg string) {
+		start := time.Now()
+		for !f(aGet()) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("%v: action state: %+v", msg, aGet())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	sendPoint(vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	getMsg(user1.Phone, "first level")
+	waitAction(func(a client.Action) bool { return a.Open }, "notification open")
+	noMsg(150*time.Millisecond, "before escalation")
+	getMsg(user2.Phone, "escalation")
+	waitAction(func(a client.Action) bool { return a.EscalationLevel == 1 }, "escalation level")
+
+	// ack the notification
+	sendPoint(action.ID, data.Point{Type: data.PointTypeAck, Text: user2.ID})
+	waitAction(func(a client.Action) bool { return !a.Open }, "notification acked")
+
+	// without escalation, all users are notified, and notifications
+	// repeat until acknowledged
+	sendPoint(vin.ID, data.Point{Type: data.PointTypeValue, Value: 0})
+	sendPoint(action.ID, data.Point{Type: data.PointTypeEscalation, Value: 0})
+	sendPoint(action.ID, data.Point{Type: data.PointTypeRepeat, Value: 0.2})
+	time.Sleep(50 * time.Millisecond)
+
+	sendPoint(vin.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+	for i := 0; i < 2; i++ {
+		got := map[string]bool{}
+		for j := 0; j < 2; j++ {
+			select {
+			case m := <-msgs:
+				got[m.to] = true
+			case <-time.After(time.Second):
+				t.Fatalf("repeat %v: timeout waiting for message", i)
+			}
+		}
+		if !got[user1.Phone] || !got[user2.Phone] {
+			t.Fatalf("repeat %v: expected both users notified: %v", i, got)
+		}
+	}
+
+	sendPoint(action.ID, data.Point{Type: data.PointTypeAck, Text: user1.ID})
+	waitAction(func(a client.Action) bool { return !a.Open }, "repeat acked")
+	// drain any message that was in flight when acked
+	time.Sleep(50 * time.Millisecond)
+	for len(msgs) > 0 {
+		<-msgs
+	}
+	noMsg(400*time.Millisecond, "after ack")
+}
@@ -3,9 +3,13 @@ package client
 import (
 	"fmt"
 	"log"
+	"path"
 	"regexp"
 	"strconv"
 	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/system"
 )
 
 type schedule struct {
@@ -14,84 +18,159 @@ type schedule struct {
 	// A Weekday specifies a day of the week (Sunday = 0, ...).
 	weekdays []time.Weekday
 	dates    []string
+	// location is the time zone start/end times, weekdays, and dates
+	// are evaluated in.
+	location *time.Location
 }
 
-func newSchedule(start, end string, weekdays []time.Weekday, dates []string) *schedule {
+func newSchedule(start, end string, weekdays []time.Weekday, dates []string,
+	location *time.Location) *schedule {
+	if location == nil {
+		location = time.UTC
+	}
+
 	return &schedule{
 		startTime: start,
 		endTime:   end,
 		weekdays:  weekdays,
 		dates:     dates,
+		location:  location,
 	}
 }
 
-func (s *schedule) activeForTime(t time.Time) (bool, error) {
-	tUTC := t.UTC()
+// scheduleWeekdays converts weekday points (indexed by weekday, Sunday = 0)
+// to a list of weekdays.
+func scheduleWeekdays(days []bool) []time.Weekday {
+	weekdays := []time.Weekday{}
+	for i, v := range days {
+		if v {
+			weekdays = append(weekdays, time.Weekday(i))
+		}
+	}
+	return weekdays
+}
 
-	// parse out hour/minute
-	matches := reHourMin.FindStringSubmatch(s.startTime)
-	if len(matches) < 3 {
-		return false, fmt.Errorf("TimeRange: invalid start: %v ", s.startTime)
+// scheduleLocation returns the location for an IANA time zone name. If the
+// name is blank or local, the time zone the instance is configured for is
+// returned.
+func scheduleLocation(tz string) (*time.Location, error) {
+	if tz != "" && tz != data.PointValueLocal {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %v: %w", tz, err)
+		}
+		return loc, nil
 	}
 
-	startHour, err := strconv.Atoi(matches[1])
-	if err != nil {
-		return false, fmt.Errorf("TimeRange: error parsing start hour: %v", matches[1])
+	// look up the system time zone each time as it can be changed while
+	// the application is running, and time.Local is only set at startup
+	zoneInfoDir, zone, err := system.GetTimezone()
+	if err != nil || zone == "" {
+		return time.Local, nil
 	}
 
-	startMin, err := strconv.Atoi(matches[2])
+	loc, err := time.LoadLocation(path.Join(zoneInfoDir, zone))
 	if err != nil {
-		return false, fmt.Errorf("TimeRange: error parsing start hour: %v", matches[1])
+		return time.Local, nil
 	}
 
-	matches = reHourMin.FindStringSubmatch(s.endTime)
+	return loc, nil
+}
+
+func parseHourMin(s string) (int, int, error) {
+	matches := reHourMin.FindStringSubmatch(s)
 	if len(matches) < 3 {
-		return false, fmt.Errorf("TimeRange: invalid end: %v ", s.endTime)
+		return 0, 0, fmt.Errorf("invalid time: %v", s)
 	}
 
-	endHour, err := strconv.Atoi(matches[1])
+	hour, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("error parsing hour: %v", matches[1])
+	}
 
+	minute, err := strconv.Atoi(matches[2])
 	if err != nil {
-		return false, fmt.Errorf("TimeRange: error parsing end hour: %v", matches[1])
+		return 0, 0, fmt.Errorf("error parsing minute: %v", matches[2])
 	}
 
-	endMin, err := strconv.Atoi(matches[2])
+	return hour, minute, nil
+}
+
+// timeRanges returns the schedule time ranges that start on the days from
+// t+fromDay to t+toDay, after weekday and date filters are applied.
+func (s *schedule) timeRanges(t time.Time, fromDay, toDay int) (timeRanges, error) {
+	startHour, startMin, err := parseHourMin(s.startTime)
+	if err != nil {
+		return nil, fmt.Errorf("TimeRange: invalid start: %v", err)
+	}
 
+	endHour, endMin, err := parseHourMin(s.endTime)
 	if err != nil {
-		return false, fmt.Errorf("TimeRange: error parsing end hour: %v", matches[1])
+		return nil, fmt.Errorf("TimeRange: invalid end: %v", err)
 	}
 
-	y := tUTC.Year()
-	m := tUTC.Month()
-	d := tUTC.Day()
+	tLoc := t.In(s.location)
+	y := tLoc.Year()
+	m := tLoc.Month()
+	d := tLoc.Day()
 
-	start := time.Date(y, m, d, startHour, startMin, 0, 0, time.UTC)
-	end := time.Date(y, m, d, endHour, endMin, 0, 0, time.UTC)
+	var trs timeRanges
 
-	timeRanges := timeRanges{
-		{start, end},
-	}
+	for day := fromDay; day <= toDay; day++ {
+		// time.Date normalizes the day, and handles DST transitions
+		start := time.Date(y, m, d+day, startHour, startMin, 0, 0, s.location)
+		end := time.Date(y, m, d+day, endHour, endMin, 0, 0, s.location)
 
-	// adjust time ranges if end time is before start
-	if !end.After(start) {
-		timeRanges[0].end = timeRanges[0].end.AddDate(0, 0, 1)
+		// adjust time range if end time is before start
+		if !end.After(start) {
+			end = time.Date(y, m, d+day+1, endHour, endMin, 0, 0, s.location)
+		}
 
-		timeRanges = append(timeRanges,
-			timeRange{start.AddDate(0, 0, -1), end},
-		)
+		trs = append(trs, timeRange{start, end})
 	}
 
-	timeRanges.filterWeekdays(s.weekdays)
-	err = timeRanges.filterDates(s.dates)
+	trs.filterWeekdays(s.weekdays)
+	err = trs.filterDates(s.dates)
+	if err != nil {
+		return nil, err
+	}
+
+	return trs, nil
+}
+
+func (s *schedule) activeForTime(t time.Time) (bool, error) {
+	// a time range can span two days, so we also need to check
+	// the range that started yesterday
+	trs, err := s.timeRanges(t, -1, 0)
 	if err != nil {
 		return false, err
 	}
 
-	if timeRanges.in(t) {
-		return true, nil
+	return trs.in(t), nil
+}
+
+// nextTransition returns the next time after t the schedule becomes
+// active or inactive. If there is no transition in the next week, false
+// is returned.
+func (s *schedule) nextTransition(t time.Time) (time.Time, bool, error) {
+	trs, err := s.timeRanges(t, -1, 7)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var next time.Time
+	found := false
+
+	for _, tr := range trs {
+		for _, b := range []time.Time{tr.start, tr.end} {
+			if b.After(t) && (!found || b.Before(next)) {
+				next = b
+				found = true
+			}
+		}
 	}
 
-	return false, nil
+	return next, found, nil
 }
 
 var reHourMin = regexp.MustCompile(`(\d{1,2}):(\d\d)`)
@@ -162,15 +241,15 @@ func (trs *timeRanges) filterDates(dates []string) error {
 				return fmt.Errorf("Invalid day: %v", d)
 			}
 
-			if year != tr.start.UTC().Year() {
+			if year != tr.start.Year() {
 				continue
 			}
 
-			if month != int(tr.start.UTC().Month()) {
+			if month != int(tr.start.Month()) {
 				continue
 			}
 
-			if day != tr.start.UTC().Day() {
+			if day != tr.start.Day() {
 				continue
 			}
 
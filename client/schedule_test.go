@@ -27,7 +27,7 @@ func (tt *testTable) run(t *testing.T, sched *schedule) {
 }
 
 func TestScheduleAllDays(t *testing.T) {
-	sched := newSchedule("2:00", "5:00", []time.Weekday{}, nil)
+	sched := newSchedule("2:00", "5:00", []time.Weekday{}, nil, time.UTC)
 
 	tests := testTable{
 		{time.Date(2021, time.February, 10, 4, 0, 0, 0, time.UTC), true},
@@ -38,7 +38,7 @@ func TestScheduleAllDays(t *testing.T) {
 }
 
 func TestScheduleWeekdays(t *testing.T) {
-	sched := newSchedule("2:00", "5:00", []time.Weekday{0, 6}, nil)
+	sched := newSchedule("2:00", "5:00", []time.Weekday{0, 6}, nil, time.UTC)
 
 	// 2021-08-09 is a Monday
 	tests := testTable{
@@ -50,7 +50,7 @@ func TestScheduleWeekdays(t *testing.T) {
 }
 
 func TestScheduleWrapDay(t *testing.T) {
-	sched := newSchedule("20:00", "2:00", []time.Weekday{}, nil)
+	sched := newSchedule("20:00", "2:00", []time.Weekday{}, nil, time.UTC)
 
 	// 2021-08-09 is a Monday
 	tests := testTable{
@@ -62,7 +62,7 @@ func TestScheduleWrapDay(t *testing.T) {
 }
 
 func TestScheduleWrapDayWeekday(t *testing.T) {
-	sched := newSchedule("20:00", "2:00", []time.Weekday{1}, nil)
+	sched := newSchedule("20:00", "2:00", []time.Weekday{1}, nil, time.UTC)
 
 	// 2021-08-09 is a Monday
 	tests := testTable{
@@ -77,7 +77,7 @@ func TestScheduleWrapDayWeekday(t *testing.T) {
 }
 
 func TestScheduleDates(t *testing.T) {
-	sched := newSchedule("2:00", "5:00", nil, []string{"2021-08-01", "2021-08-09", "2021-08-15"})
+	sched := newSchedule("2:00", "5:00", nil, []string{"2021-08-01", "2021-08-09", "2021-08-15"}, time.UTC)
 
 	// 2021-08-09 is a Monday
 	tests := testTable{
@@ -92,7 +92,7 @@ func TestScheduleDates(t *testing.T) {
 }
 
 func TestScheduleWrapDates(t *testing.T) {
-	sched := newSchedule("20:00", "6:00", nil, []string{"2021-08-01", "2021-08-09", "2021-08-15"})
+	sched := newSchedule("20:00", "6:00", nil, []string{"2021-08-01", "2021-08-09", "2021-08-15"}, time.UTC)
 
 	// 2021-08-09 is a Monday
 	tests := testTable{
@@ -106,3 +106,145 @@ func TestScheduleWrapDates(t *testing.T) {
 
 	tests.run(t, sched)
 }
+
+func TestScheduleTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	sched := newSchedule("8:00", "17:00", nil, nil, loc)
+
+	// DST starts 2024-03-10 in the US, so the local schedule should
+	// shift by an hour relative to UTC
+	tests := testTable{
+		{time.Date(2024, time.January, 10, 12, 30, 0, 0, time.UTC), false},
+		{time.Date(2024, time.January, 10, 13, 30, 0, 0, time.UTC), true},
+		{time.Date(2024, time.January, 10, 21, 30, 0, 0, time.UTC), true},
+		{time.Date(2024, time.January, 10, 22, 30, 0, 0, time.UTC), false},
+		{time.Date(2024, time.July, 10, 11, 30, 0, 0, time.UTC), false},
+		{time.Date(2024, time.July, 10, 12, 30, 0, 0, time.UTC), true},
+		{time.Date(2024, time.July, 10, 20, 30, 0, 0, time.UTC), true},
+		{time.Date(2024, time.July, 10, 21, 30, 0, 0, time.UTC), false},
+		// day DST starts
+		{time.Date(2024, time.March, 10, 12, 30, 0, 0, time.UTC), true},
+		// day DST ends
+		{time.Date(2024, time.November, 3, 12, 30, 0, 0, time.UTC), false},
+		{time.Date(2024, time.November, 3, 13, 30, 0, 0, time.UTC), true},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestConditionScheduleTimezone(t *testing.T) {
+	// a blank timezone is UTC as the frontend stores schedules in UTC
+	c := Condition{Start: "8:00", End: "17:00"}
+
+	sched, err := c.schedule()
+	if err != nil {
+		t.Fatal("Error getting schedule: ", err)
+	}
+
+	if sched.location != time.UTC {
+		t.Error("expected UTC for blank timezone, got: ", sched.location)
+	}
+
+	c.Timezone = "America/New_York"
+
+	sched, err = c.schedule()
+	if err != nil {
+		t.Fatal("Error getting schedule: ", err)
+	}
+
+	if sched.location.String() != c.Timezone {
+		t.Error("expected timezone location, got: ", sched.location)
+	}
+
+	c.Timezone = "bad/zone"
+
+	_, err = c.schedule()
+	if err == nil {
+		t.Error("expected error for invalid timezone")
+	}
+}
+
+func TestScheduleTimezoneWrapWeekdayDate(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	// 2024-01-12 is a Friday
+	sched := newSchedule("22:00", "6:00", []time.Weekday{time.Friday}, nil, loc)
+
+	tests := testTable{
+		// Friday 23:00 local is Saturday 04:00 UTC
+		{time.Date(2024, time.January, 13, 4, 0, 0, 0, time.UTC), true},
+		// Saturday 05:00 local is Saturday 10:00 UTC
+		{time.Date(2024, time.January, 13, 10, 0, 0, 0, time.UTC), true},
+		// Saturday 07:00 local
+		{time.Date(2024, time.January, 13, 12, 0, 0, 0, time.UTC), false},
+		// Thursday 23:00 local
+		{time.Date(2024, time.January, 12, 4, 0, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+
+	sched = newSchedule("20:00", "23:00", nil, []string{"2024-01-12"}, loc)
+
+	tests = testTable{
+		// 2024-01-12 21:00 local is 2024-01-13 02:00 UTC
+		{time.Date(2024, time.January, 13, 2, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, time.January, 12, 2, 0, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleNextTransition(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	tests := []struct {
+		sched    *schedule
+		t        time.Time
+		expected time.Time
+	}{
+		{
+			newSchedule("8:00", "17:00", nil, nil, loc),
+			time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC),
+			time.Date(2024, time.January, 10, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			newSchedule("8:00", "17:00", nil, nil, loc),
+			time.Date(2024, time.January, 10, 14, 0, 0, 0, time.UTC),
+			time.Date(2024, time.January, 10, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			// across DST start, 8:00 EDT is 12:00 UTC
+			newSchedule("8:00", "17:00", nil, nil, loc),
+			time.Date(2024, time.March, 9, 23, 0, 0, 0, time.UTC),
+			time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			// 2021-08-09 is a Monday
+			newSchedule("2:00", "5:00", []time.Weekday{time.Monday}, nil, time.UTC),
+			time.Date(2021, time.August, 10, 1, 0, 0, 0, time.UTC),
+			time.Date(2021, time.August, 16, 2, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		next, ok, err := test.sched.nextTransition(test.t)
+		if err != nil {
+			t.Errorf("got err: %v for time %v", err, test.t)
+		}
+
+		if !ok || !next.Equal(test.expected) {
+			t.Errorf("expected next transition %v for time %v, got %v", test.expected,
+				test.t, next)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// spoolCompactSize is the number of consumed bytes at the start of the spool
+// file that triggers a compaction
+const spoolCompactSize = 4 * 1024 * 1024
+
+// spoolRecordMax is the largest record that can be stored in a spool. Larger
+// records in a spool file are treated as corruption.
+const spoolRecordMax = 16 * 1024 * 1024
+
+// spoolPath returns the path of a spool file in the SIOT data directory
+func spoolPath(name string) string {
+	dataDir := os.Getenv("SIOT_DATA")
+	if dataDir == "" {
+		dataDir = "./"
+	}
+
+	return filepath.Join(dataDir, "spool", name)
+}
+
+// spool is a persistent FIFO queue of records. Records are appended to a file
+// with a length prefix, and the offset of the oldest record is stored in a
+// separate position file so that records survive restarts. If more than max
+// records are queued, the oldest records are dropped.
+type spool struct {
+	lock    sync.Mutex
+	path    string
+	max     int
+	f       *os.File
+	read    int64
+	size    int64
+	count   int
+	dropped int
+	// head is the sequence number of the oldest record
+	head uint64
+}
+
+// newSpool opens or creates a spool file. Any partially written record at the
+// end of the file is discarded.
+func newSpool(path string, max int) (*spool, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spool{path: path, max: max, f: f}
+
+	pos, err := os.ReadFile(s.posPath())
+	if err == nil && len(pos) == 8 {
+		s.read = int64(binary.BigEndian.Uint64(pos))
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if s.read > info.Size() {
+		s.read = 0
+	}
+
+	// count records and find the end of the last complete record
+	s.size = s.read
+	for {
+		l, err := s.recordLen(s.size)
+		if err != nil || s.size+4+l > info.Size() {
+			break
+		}
+		s.size += 4 + l
+		s.count++
+	}
+
+	if s.size != info.Size() {
+		err := f.Truncate(s.size)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *spool) posPath() string {
+	return s.path + ".pos"
+}
+
+// recordLen returns the length of the record at offset
+func (s *spool) recordLen(offset int64) (int64, error) {
+	var b [4]byte
+	_, err := s.f.ReadAt(b[:], offset)
+	if err != nil {
+		return 0, err
+	}
+
+	l := int64(binary.BigEndian.Uint32(b[:]))
+	if l > spoolRecordMax {
+		return 0, fmt.Errorf("spool record too large: %v", l)
+	}
+
+	return l, nil
+}
+
+// Push appends records to the spool. If the spool is full, the oldest records
+// are dropped.
+func (s *spool) Push(records ...[]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(records) <= 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, r := range records {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(r)))
+		buf = append(buf, r...)
+	}
+
+	_, err := s.f.WriteAt(buf, s.size)
+	if err != nil {
+		return err
+	}
+
+	s.size += int64(len(buf))
+	s.count += len(records)
+
+	if s.max > 0 && s.count > s.max {
+		drop := s.count - s.max
+		err := s.pop(drop)
+		if err != nil {
+			return err
+		}
+		s.dropped += drop
+	}
+
+	return nil
+}
+
+// Peek returns up to n of the oldest records without removing them, and the
+// sequence number of the first record which is passed to Pop.
+func (s *spool) Peek(n int) ([][]byte, uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var ret [][]byte
+	offset := s.read
+
+	for i := 0; i < n && offset < s.size; i++ {
+		l, err := s.recordLen(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		r := make([]byte, l)
+		_, err = s.f.ReadAt(r, offset+4)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, 0, err
+		}
+
+		ret = append(ret, r)
+		offset += 4 + l
+	}
+
+	return ret, s.head, nil
+}
+
+// Pop removes n records returned by Peek starting at sequence number seq.
+// Records that were already dropped because the spool was full are skipped.
+func (s *spool) Pop(seq uint64, n int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	end := seq + uint64(n)
+	if end <= s.head {
+		return nil
+	}
+
+	return s.pop(int(end - s.head))
+}
+
+// Drop is like Pop, but the records are counted as dropped. This is used
+// when records are rejected by the destination.
+func (s *spool) Drop(seq uint64, n int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	end := seq + uint64(n)
+	if end <= s.head {
+		return nil
+	}
+
+	drop := int(end - s.head)
+	s.dropped += drop
+	return s.pop(drop)
+}
+
+func (s *spool) pop(n int) error {
+	for i := 0; i < n && s.read < s.size; i++ {
+		l, err := s.recordLen(s.read)
+		if err != nil {
+			return err
+		}
+		s.read += 4 + l
+		s.count--
+		s.head++
+	}
+
+	if s.read >= s.size {
+		// spool is empty, so start over
+		err := s.f.Truncate(0)
+		if err != nil {
+			return err
+		}
+		s.read, s.size, s.count = 0, 0, 0
+	} else if s.read > spoolCompactSize && s.read > s.size/2 {
+		err := s.compact()
+		if err != nil {
+			return err
+		}
+	}
+
+	var pos [8]byte
+	binary.BigEndian.PutUint64(pos[:], uint64(s.read))
+	return os.WriteFile(s.posPath(), pos[:], 0644)
+}
+
+// compact copies the unread records to a new file
+func (s *spool) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, io.NewSectionReader(s.f, s.read, s.size-s.read))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// the new file starts with the unread records. Write the position
+	// first, so a crash before the rename replays records instead of
+	// losing them.
+	err = os.WriteFile(s.posPath(), make([]byte, 8), 0644)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	s.f.Close()
+	s.f = tmp
+	s.size -= s.read
+	s.read = 0
+
+	return nil
+}
+
+// SetMax sets the maximum number of records in the spool. Records are
+// dropped on the next Push if the spool is over the limit.
+func (s *spool) SetMax(max int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.max = max
+}
+
+// Len returns the number of records in the spool
+func (s *spool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.count
+}
+
+// Dropped returns the number of records dropped since the spool was opened
+// because it was full
+func (s *spool) Dropped() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dropped
+}
+
+// Close closes the spool file
+func (s *spool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.f.Close()
+}
+
+// spoolStats reports the depth and dropped count of a spool as queueDepth and
+// queueDropped points on a node. The dropped count is a running total across
+// restarts.
+type spoolStats struct {
+	nc          *nats.Conn
+	nodeID      string
+	dropBase    int
+	lastDepth   int
+	lastDropped int
+}
+
+// newSpoolStats creates stats for a node. dropped is the current
+// queueDropped value of the node.
+func newSpoolStats(nc *nats.Conn, nodeID string, dropped int) *spoolStats {
+	return &spoolStats{nc: nc, nodeID: nodeID, dropBase: dropped, lastDepth: -1}
+}
+
+// report sends the stats points if they changed since the last report
+func (ss *spoolStats) report(s *spool) {
+	depth := s.Len()
+	dropped := ss.dropBase + s.Dropped()
+	if depth == ss.lastDepth && dropped == ss.lastDropped {
+		return
+	}
+
+	err := SendNodePoints(ss.nc, ss.nodeID, data.Points{
+		{Time: time.Now(), Type: data.PointTypeQueueDepth, Value: float64(depth)},
+		{Time: time.Now(), Type: data.PointTypeQueueDropped, Value: float64(dropped)},
+	}, false)
+	if err != nil {
+		log.Println("Error sending queue stats:", err)
+		return
+	}
+
+	ss.lastDepth, ss.lastDropped = depth, dropped
+}
+
+// setDropped is called when the queueDropped point is set by a user so the
+// count can be reset
+func (ss *spoolStats) setDropped(dropped int, s *spool) {
+	ss.dropBase = dropped - s.Dropped()
+	ss.lastDropped = dropped
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool", "test")
+
+	s, err := newSpool(path, 3)
+	if err != nil {
+		t.Fatal("Error opening spool: ", err)
+	}
+
+	err = s.Push([]byte("a"), []byte("b"))
+	if err != nil {
+		t.Fatal("Error pushing: ", err)
+	}
+
+	recs, seq, err := s.Peek(10)
+	if err != nil {
+		t.Fatal("Error peeking: ", err)
+	}
+
+	if len(recs) != 2 || string(recs[0]) != "a" || string(recs[1]) != "b" {
+		t.Fatalf("wrong records: %q", recs)
+	}
+
+	// spool is full, so "a" and "b" are dropped while "b" is in flight
+	err = s.Push([]byte("c"), []byte("d"), []byte("e"))
+	if err != nil {
+		t.Fatal("Error pushing: ", err)
+	}
+
+	if s.Len() != 3 || s.Dropped() != 2 {
+		t.Fatalf("expected 3 records and 2 dropped, got %v, %v", s.Len(), s.Dropped())
+	}
+
+	// popping the in flight records should not remove newer records
+	err = s.Pop(seq, len(recs))
+	if err != nil {
+		t.Fatal("Error popping: ", err)
+	}
+
+	if s.Len() != 3 {
+		t.Fatal("Pop removed records that were not sent: ", s.Len())
+	}
+
+	recs, seq, err = s.Peek(1)
+	if err != nil {
+		t.Fatal("Error peeking: ", err)
+	}
+
+	if string(recs[0]) != "c" {
+		t.Fatalf("wrong record: %q", recs)
+	}
+
+	err = s.Pop(seq, 1)
+	if err != nil {
+		t.Fatal("Error popping: ", err)
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal("Error closing: ", err)
+	}
+
+	// simulate a partial write at the end of the file
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 10, 'x'})
+	f.Close()
+
+	s, err = newSpool(path, 3)
+	if err != nil {
+		t.Fatal("Error reopening spool: ", err)
+	}
+	defer s.Close()
+
+	recs, _, err = s.Peek(10)
+	if err != nil {
+		t.Fatal("Error peeking: ", err)
+	}
+
+	if len(recs) != 2 || string(recs[0]) != "d" || string(recs[1]) != "e" {
+		t.Fatalf("wrong records after reopen: %q", recs)
+	}
+
+	recs, seq, _ = s.Peek(10)
+	err = s.Drop(seq, len(recs))
+	if err != nil {
+		t.Fatal("Error dropping: ", err)
+	}
+
+	if s.Len() != 0 || s.Dropped() != 2 {
+		t.Fatalf("expected empty spool with 2 dropped, got %v, %v", s.Len(), s.Dropped())
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != 0 {
+		t.Error("empty spool file was not truncated: ", info.Size())
+	}
+}
@@ -29,6 +29,36 @@ func SubjectNodeHRPoints(nodeID string) string {
 	return fmt.Sprintf("phr.%v", nodeID)
 }
 
+// SubjectAlarms constructs a NATS subject used to request all alarms at or
+// below a node
+func SubjectAlarms(nodeID string) string {
+	return fmt.Sprintf("alarms.%v", nodeID)
+}
+
+// SubjectEvent constructs a NATS subject used to publish events for a node
+func SubjectEvent(nodeID string) string {
+	return fmt.Sprintf("event.%v", nodeID)
+}
+
+// SubjectEvents constructs a NATS subject used to query events at or below
+// a node
+func SubjectEvents(nodeID string) string {
+	return fmt.Sprintf("events.%v", nodeID)
+}
+
+// SubjectHistory constructs a NATS subject used to query a history backend.
+// nodeID is the db node for Influx, or the root node for the local store
+// history.
+func SubjectHistory(nodeID string) string {
+	return fmt.Sprintf("history.%v", nodeID)
+}
+
+// SubjectNodeHistory constructs a NATS subject used to query the history
+// of a node from the configured history backend
+func SubjectNodeHistory(nodeID string) string {
+	return fmt.Sprintf("nodeHistory.%v", nodeID)
+}
+
 // Destination indicates the destination for generated points, including the
 // point type and key
 type Destination struct {
@@ -68,3 +98,16 @@ func (sd Destination) Subject(originID string, parentID string) string {
 	}
 	return SubjectNodePoints(destID)
 }
+
+// SubjectPointBatch provides the subject used to send a batch of node and edge
+// point messages. This is used by the sync client to reduce the number of
+// messages sent upstream.
+func SubjectPointBatch() string {
+	return "pointBatch"
+}
+
+// SubjectSyncDiff constructs a NATS subject used to request the nodes that
+// differ between the local instance and the upstream of a sync node
+func SubjectSyncDiff(syncNodeID string) string {
+	return fmt.Sprintf("syncDiff.%v", syncNodeID)
+}
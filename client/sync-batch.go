@@ -0,0 +1,78 @@
+package client
+
+import (
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// syncBatchMax is the max number of point messages in a batch. The batch is
+// sent early if it fills up before the batch window expires.
+const syncBatchMax = 1000
+
+// syncBatch collects local point messages during the batch window. Points
+// with the same type and key for a node are deduplicated so only the latest
+// value is sent. Messages are kept in the order they were first seen.
+type syncBatch struct {
+	msgs data.Nodes
+	// index of each node or edge message in msgs
+	index map[string]int
+	// size is the number of bytes the messages would take if they were
+	// sent individually
+	size int
+}
+
+func newSyncBatch() *syncBatch {
+	return &syncBatch{index: make(map[string]int)}
+}
+
+// add adds node points to the batch, or edge points if parent is set. size
+// is the size of the message if it was sent individually.
+func (b *syncBatch) add(id, parent string, points data.Points, size int) {
+	b.size += size
+
+	key := id + "." + parent
+	i, ok := b.index[key]
+	if !ok {
+		i = len(b.msgs)
+		b.index[key] = i
+		b.msgs = append(b.msgs, data.NodeEdge{ID: id, Parent: parent})
+	}
+
+	msg := &b.msgs[i]
+	existing := &msg.Points
+	if parent != "" {
+		existing = &msg.EdgePoints
+	}
+
+	for _, p := range points {
+		found := false
+		for j := range *existing {
+			e := &(*existing)[j]
+			if e.IsMatch(p.Type, p.Key) {
+				found = true
+				if !p.Time.Before(e.Time) {
+					*e = p
+				}
+				break
+			}
+		}
+
+		if !found {
+			*existing = append(*existing, p)
+		}
+	}
+}
+
+// len returns the number of messages in the batch
+func (b *syncBatch) len() int {
+	return len(b.msgs)
+}
+
+// take returns the messages and the size they would have taken if sent
+// individually, and resets the batch
+func (b *syncBatch) take() (data.Nodes, int) {
+	msgs, size := b.msgs, b.size
+	b.msgs = nil
+	b.index = make(map[string]int)
+	b.size = 0
+	return msgs, size
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// GetSyncDiff returns the nodes in a subtree whose hashes differ between the
+// local instance and the upstream of a sync node. If nodeID is empty, the
+// whole tree is compared. Maps to the `syncDiff.<id>` NATS API.
+func GetSyncDiff(nc *nats.Conn, syncNodeID, nodeID string) ([]data.SyncDiffNode, error) {
+	d, err := json.Marshal(data.SyncDiffQuery{NodeID: nodeID})
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := nc.Request(SubjectSyncDiff(syncNodeID), d, time.Second*30)
+	if err != nil {
+		return nil, err
+	}
+
+	var results data.SyncDiffResults
+	err = json.Unmarshal(msg.Data, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	if results.ErrorMessage != "" {
+		return nil, errors.New(results.ErrorMessage)
+	}
+
+	return results.Nodes, nil
+}
+
+func (up *SyncClient) handleDiff(msg *nats.Msg, connected bool) {
+	var results data.SyncDiffResults
+
+	err := func() error {
+		var q data.SyncDiffQuery
+		if len(msg.Data) > 0 {
+			err := json.Unmarshal(msg.Data, &q)
+			if err != nil {
+				return fmt.Errorf("error decoding query: %w", err)
+			}
+		}
+
+		if !connected || up.ncRemote == nil {
+			return errors.New("sync is not connected")
+		}
+
+		if q.NodeID == "" {
+			q.NodeID = up.rootLocal.ID
+		}
+
+		if up.filter.Active() {
+			roots, err := GetNodes(up.nc, "all", up.rootLocal.ID, "", false)
+			if err != nil || len(roots) <= 0 {
+				return fmt.Errorf("error getting root node: %v", err)
+			}
+
+			err = up.filter.Update(up.nc, roots[0])
+			if err != nil {
+				return fmt.Errorf("error updating sync filter: %w", err)
+			}
+		}
+
+		var err error
+		results.Nodes, err = up.diff(q.NodeID)
+		return err
+	}()
+
+	if err != nil {
+		results.ErrorMessage = err.Error()
+	}
+
+	if results.Nodes == nil {
+		results.Nodes = []data.SyncDiffNode{}
+	}
+
+	d, err := json.Marshal(results)
+	if err != nil {
+		log.Println("Error encoding sync diff:", err)
+		return
+	}
+
+	err = msg.Respond(d)
+	if err != nil {
+		log.Println("Error responding to sync diff request:", err)
+	}
+}
+
+// diff returns the nodes in the subtree of id that are not in sync
+func (up *SyncClient) diff(id string) ([]data.SyncDiffNode, error) {
+	locals, err := GetNodes(up.nc, "all", id, "", true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting local node: %w", err)
+	}
+
+	if len(locals) <= 0 {
+		return nil, fmt.Errorf("node not found: %v", id)
+	}
+
+	local := locals[0]
+	if id == up.rootLocal.ID {
+		local.Parent = up.rootLocal.Parent
+	}
+
+	remotes, err := GetNodes(up.ncRemote, "all", id, "", true)
+	if err != nil && err != data.ErrDocumentNotFound {
+		return nil, fmt.Errorf("error getting upstream node: %w", err)
+	}
+
+	for _, r := range remotes {
+		if r.Parent == local.Parent || id == up.rootLocal.ID {
+			return up.diffNode(local, r)
+		}
+	}
+
+	return []data.SyncDiffNode{diffEntry(&local, nil)}, nil
+}
+
+func (up *SyncClient) diffNode(local, remote data.NodeEdge) ([]data.SyncDiffNode, error) {
+	localHash, remoteHash, err := up.syncHashes(local, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	if localHash == remoteHash {
+		return nil, nil
+	}
+
+	entry := diffEntry(&local, &remote)
+	entry.LocalHash, entry.RemoteHash = localHash, remoteHash
+	entry.Points = up.diffPoints(local.Points, remote.Points, true)
+	if local.ID != up.rootLocal.ID {
+		entry.Points = append(entry.Points,
+			up.diffPoints(local.EdgePoints, remote.EdgePoints, false)...)
+	}
+
+	ret := []data.SyncDiffNode{entry}
+
+	children, err := GetNodes(up.nc, local.ID, "all", "", true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting local children: %w", err)
+	}
+
+	remoteChildren, err := GetNodes(up.ncRemote, remote.ID, "all", "", true)
+	if err != nil {
+		return nil, fmt.Errorf("error getting upstream children: %w", err)
+	}
+
+	remoteProcessed := make(map[int]bool)
+
+	for _, c := range children {
+		found := false
+		for i, rc := range remoteChildren {
+			if c.ID != rc.ID {
+				continue
+			}
+
+			found = true
+			remoteProcessed[i] = true
+
+			if !up.filter.Synced(c.ID) {
+				break
+			}
+
+			d, err := up.diffNode(c, rc)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, d...)
+			break
+		}
+
+		if !found && up.filter.Synced(c.ID) {
+			ret = append(ret, diffEntry(&c, nil))
+		}
+	}
+
+	for i, rc := range remoteChildren {
+		if remoteProcessed[i] {
+			continue
+		}
+
+		synced, err := up.filter.RemoteSynced(up.ncRemote, rc)
+		if err != nil {
+			return nil, err
+		}
+
+		if synced {
+			ret = append(ret, diffEntry(nil, &rc))
+		}
+	}
+
+	return ret, nil
+}
+
+// diffPoints returns the synced point types that differ
+func (up *SyncClient) diffPoints(local, remote data.Points, filter bool) []string {
+	var ret []string
+
+	differs := func(p data.Point, others data.Points) bool {
+		for _, o := range others {
+			if p.IsMatch(o.Type, o.Key) {
+				return p.CRC() != o.CRC()
+			}
+		}
+		return true
+	}
+
+	add := func(p data.Point) {
+		if filter && !up.filter.PointSynced(p.Type) {
+			return
+		}
+		name := p.Type
+		if p.Key != "" && p.Key != "0" {
+			name += ":" + p.Key
+		}
+		for _, n := range ret {
+			if n == name {
+				return
+			}
+		}
+		ret = append(ret, name)
+	}
+
+	for _, p := range local {
+		if differs(p, remote) {
+			add(p)
+		}
+	}
+
+	for _, p := range remote {
+		if differs(p, local) {
+			add(p)
+		}
+	}
+
+	return ret
+}
+
+// diffEntry describes a node that exists on one or both sides
+func diffEntry(local, remote *data.NodeEdge) data.SyncDiffNode {
+	n := local
+	if n == nil {
+		n = remote
+	}
+
+	ret := data.SyncDiffNode{
+		ID:          n.ID,
+		Parent:      n.Parent,
+		Type:        n.Type,
+		Description: n.Points.Desc(),
+		Local:       local != nil,
+		Remote:      remote != nil,
+	}
+
+	if local != nil {
+		ret.LocalHash = local.Hash
+	}
+
+	if remote != nil {
+		ret.RemoteHash = remote.Hash
+	}
+
+	return ret
+}
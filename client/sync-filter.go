@@ -0,0 +1,332 @@
+package client
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// syncFilter decides which nodes and points a sync client sends upstream.
+// Nodes are excluded with their subtree if their ID or type is in an exclude
+// list. If include nodes or node types are configured, only the subtrees of
+// matching nodes (and the nodes above them) are synced. Point type filters
+// apply to node points only as edge points describe the tree structure.
+//
+// The filtered hash of each node is calculated the same way the store
+// calculates node hashes, but only includes the synced points and children.
+// Comparing filtered hashes keeps the sync from seeing a permanent mismatch
+// when part of the tree is not synced.
+type syncFilter struct {
+	lock              sync.Mutex
+	includeNodeTypes  []string
+	excludeNodeTypes  []string
+	includeNodes      []string
+	excludeNodes      []string
+	includePointTypes []string
+	excludePointTypes []string
+
+	// state of the local tree from the last walk. nodes contains the synced
+	// nodes, the value is true if the whole subtree is included. skipped
+	// contains nodes that are not synced.
+	nodes   map[string]bool
+	skipped map[string]bool
+	// hashes is keyed by id:parent as the hash includes the edge points
+	hashes map[string]uint32
+	// pending holds points for new nodes until the edge is seen
+	pending map[string]data.Points
+}
+
+func newSyncFilter(c Sync) *syncFilter {
+	f := &syncFilter{}
+	f.SetConfig(c)
+	return f
+}
+
+// SetConfig updates the filter lists. Update must be called after this to
+// refresh the local tree state.
+func (f *syncFilter) SetConfig(c Sync) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.includeNodeTypes = c.IncludeNodeTypes
+	f.excludeNodeTypes = c.ExcludeNodeTypes
+	f.includeNodes = c.IncludeNodes
+	f.excludeNodes = c.ExcludeNodes
+	f.includePointTypes = c.IncludePointTypes
+	f.excludePointTypes = c.ExcludePointTypes
+	f.reset()
+}
+
+func (f *syncFilter) reset() {
+	f.nodes = make(map[string]bool)
+	f.skipped = make(map[string]bool)
+	f.hashes = make(map[string]uint32)
+	f.pending = make(map[string]data.Points)
+}
+
+// Active returns true if any filters are configured
+func (f *syncFilter) Active() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.nodeRules() || f.pointRules()
+}
+
+func (f *syncFilter) nodeRules() bool {
+	return f.includeRules() || len(f.excludeNodeTypes) > 0 || len(f.excludeNodes) > 0
+}
+
+func (f *syncFilter) includeRules() bool {
+	return len(f.includeNodeTypes) > 0 || len(f.includeNodes) > 0
+}
+
+func (f *syncFilter) pointRules() bool {
+	return len(f.includePointTypes) > 0 || len(f.excludePointTypes) > 0
+}
+
+func (f *syncFilter) excluded(id, typ string) bool {
+	return slices.Contains(f.excludeNodes, id) || slices.Contains(f.excludeNodeTypes, typ)
+}
+
+func (f *syncFilter) included(id, typ string) bool {
+	return slices.Contains(f.includeNodes, id) || slices.Contains(f.includeNodeTypes, typ)
+}
+
+func (f *syncFilter) pointSynced(typ string) bool {
+	if slices.Contains(f.excludePointTypes, typ) {
+		return false
+	}
+
+	return len(f.includePointTypes) <= 0 || slices.Contains(f.includePointTypes, typ)
+}
+
+// Points returns the node points that are synced
+func (f *syncFilter) Points(points data.Points) data.Points {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.points(points)
+}
+
+func (f *syncFilter) points(points data.Points) data.Points {
+	if !f.pointRules() {
+		return points
+	}
+
+	var ret data.Points
+	for _, p := range points {
+		if f.pointSynced(p.Type) {
+			ret = append(ret, p)
+		}
+	}
+
+	return ret
+}
+
+// PointSynced returns true if a point type is synced
+func (f *syncFilter) PointSynced(typ string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.pointSynced(typ)
+}
+
+// walk calculates the filtered hash of a node and its children. If record is
+// set, the state of the local tree is saved.
+func (f *syncFilter) walk(nc *nats.Conn, node data.NodeEdge, root, parentIncluded,
+	record bool) (bool, uint32, error) {
+	included := parentIncluded || f.included(node.ID, node.Type)
+	if root {
+		included = !f.includeRules()
+	} else if f.excluded(node.ID, node.Type) {
+		if record {
+			f.skipped[node.ID] = true
+		}
+		return false, 0, nil
+	}
+
+	children, err := GetNodes(nc, node.ID, "all", "", true)
+	if err != nil {
+		return false, 0, err
+	}
+
+	var hash uint32
+	childSynced := false
+
+	for _, c := range children {
+		synced, h, err := f.walk(nc, c, false, included, record)
+		if err != nil {
+			return false, 0, err
+		}
+
+		if synced {
+			childSynced = true
+			hash ^= h
+		}
+	}
+
+	if !root && !included && !childSynced {
+		if record {
+			f.skipped[node.ID] = true
+		}
+		return false, 0, nil
+	}
+
+	for _, p := range f.points(node.Points) {
+		hash ^= p.CRC()
+	}
+
+	// the edge points of the root node are not synced
+	if !root {
+		for _, p := range node.EdgePoints {
+			hash ^= p.CRC()
+		}
+	}
+
+	if record {
+		f.nodes[node.ID] = f.nodes[node.ID] || included
+		f.hashes[node.ID+":"+node.Parent] = hash
+	}
+
+	return true, hash, nil
+}
+
+// Update walks the local tree starting at the root node and saves which
+// nodes are synced and their filtered hashes.
+func (f *syncFilter) Update(nc *nats.Conn, root data.NodeEdge) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// any pending points are dropped as the hash sync will send them
+	f.reset()
+
+	if !f.nodeRules() && !f.pointRules() {
+		return nil
+	}
+
+	_, _, err := f.walk(nc, root, true, false, true)
+	return err
+}
+
+// Hash returns the filtered hash of a local node from the last Update
+func (f *syncFilter) Hash(node data.NodeEdge) (uint32, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	h, ok := f.hashes[node.ID+":"+node.Parent]
+	return h, ok
+}
+
+// RemoteHash calculates the filtered hash of a remote node
+func (f *syncFilter) RemoteHash(nc *nats.Conn, node data.NodeEdge, root bool) (uint32, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, h, err := f.walk(nc, node, root, f.nodes[node.Parent], false)
+	return h, err
+}
+
+// RemoteSynced returns true if a remote node that does not exist locally
+// should be synced down
+func (f *syncFilter) RemoteSynced(nc *nats.Conn, node data.NodeEdge) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.nodeRules() {
+		return true, nil
+	}
+	synced, _, err := f.walk(nc, node, false, f.nodes[node.Parent], false)
+	return synced, err
+}
+
+// Synced returns true if a local node is synced
+func (f *syncFilter) Synced(id string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.nodeRules() {
+		return true
+	}
+	_, ok := f.nodes[id]
+	return ok
+}
+
+// classify determines if a new node is synced from the state of its parent
+func (f *syncFilter) classify(id, typ, parent string) {
+	parentIncluded, parentSynced := f.nodes[parent]
+	if !parentSynced || f.excluded(id, typ) {
+		f.skipped[id] = true
+		return
+	}
+
+	included := parentIncluded || f.included(id, typ)
+	if f.includeRules() && !included {
+		f.skipped[id] = true
+		return
+	}
+
+	f.nodes[id] = included
+}
+
+// LocalNodePoints returns the points of a local node that should be sent
+// upstream. Points for nodes that have not been seen yet are held until the
+// edge of the node is seen.
+func (f *syncFilter) LocalNodePoints(nc *nats.Conn, id string, points data.Points) data.Points {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.nodeRules() {
+		return f.points(points)
+	}
+
+	_, synced := f.nodes[id]
+	if !synced && !f.skipped[id] {
+		nodes, err := GetNodes(nc, "all", id, "", false)
+		if err != nil || len(nodes) <= 0 {
+			// new node, wait for the edge points
+			f.pending[id] = append(f.pending[id], points...)
+			return nil
+		}
+
+		for _, n := range nodes {
+			f.classify(n.ID, n.Type, n.Parent)
+		}
+
+		_, synced = f.nodes[id]
+	}
+
+	if !synced {
+		return nil
+	}
+
+	return f.points(points)
+}
+
+// LocalEdgePoints returns true if local edge points should be sent upstream.
+// If a new node is synced, any node points received before the edge are
+// returned so they can be sent first.
+func (f *syncFilter) LocalEdgePoints(id, parent string, points data.Points) (bool, data.Points) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.nodeRules() {
+		return true, nil
+	}
+
+	_, synced := f.nodes[id]
+	if !synced && !f.skipped[id] {
+		for _, p := range points {
+			if p.Type == data.PointTypeNodeType {
+				f.classify(id, p.Text, parent)
+				break
+			}
+		}
+		_, synced = f.nodes[id]
+	}
+
+	if !synced {
+		if f.skipped[id] {
+			delete(f.pending, id)
+		}
+		return false, nil
+	}
+
+	pending := f.points(f.pending[id])
+	delete(f.pending, id)
+
+	return true, pending
+}
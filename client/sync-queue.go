@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+const (
+	// syncQueueSize is the default max number of point messages queued
+	// while the sync connection is down
+	syncQueueSize = 100000
+	// syncDrainBatch is the max number of queued messages sent at once
+	syncDrainBatch = 100
+	// syncDrainPeriod is how often the queue is checked for messages
+	syncDrainPeriod = 250 * time.Millisecond
+	syncBackoffMax  = 30 * time.Second
+	// syncStatsPeriod is how often queue stats are reported
+	syncStatsPeriod = 10 * time.Second
+	// syncFailoverCount is the default number of failed connects before
+	// switching to the next upstream URI
+	syncFailoverCount = 3
+	// syncFailbackPeriod is the default period higher priority upstream
+	// URIs are checked while connected to a backup
+	syncFailbackPeriod = time.Minute
+)
+
+// encodeSyncRecord encodes a message for the sync queue. The record contains
+// the time it was queued, the NATS subject, and the message data.
+func encodeSyncRecord(t time.Time, subject string, msg []byte) []byte {
+	ret := binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+	ret = binary.BigEndian.AppendUint16(ret, uint16(len(subject)))
+	ret = append(ret, subject...)
+	return append(ret, msg...)
+}
+
+// decodeSyncRecord returns the queue time, subject, and message data
+func decodeSyncRecord(r []byte) (time.Time, string, []byte, error) {
+	if len(r) < 10 {
+		return time.Time{}, "", nil, errors.New("sync record too short")
+	}
+
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(r)))
+	l := int(binary.BigEndian.Uint16(r[8:]))
+
+	if len(r) < 10+l {
+		return time.Time{}, "", nil, errors.New("sync record subject too short")
+	}
+
+	return t, string(r[10 : 10+l]), r[10+l:], nil
+}
+
+// queueMsg adds a message to the outbound queue
+func (up *SyncClient) queueMsg(subject string, msg []byte) {
+	err := up.queue.Push(encodeSyncRecord(time.Now(), subject, msg))
+	if err != nil {
+		log.Println("Sync: error queuing points:", err)
+	}
+}
+
+// syncRecordBatch combines the leading node and edge point records into
+// messages for one point batch. Records are not deduplicated, so every queued
+// point is sent. It stops at the first record that is not node or edge
+// points, is expired, or would make the batch larger than maxSize. It returns
+// the messages, the number of records used, and their size if they were sent
+// individually.
+func syncRecordBatch(records [][]byte, maxAge time.Duration, maxSize int) (data.Nodes, int, int) {
+	var msgs data.Nodes
+	size := 0
+
+	for _, r := range records {
+		t, subject, msg, err := decodeSyncRecord(r)
+		if err != nil || (maxAge > 0 && time.Since(t) > maxAge) {
+			break
+		}
+
+		chunks := strings.Split(subject, ".")
+		if chunks[0] != "p" || len(chunks) < 2 || len(chunks) > 3 {
+			break
+		}
+
+		if size+len(subject)+len(msg) > maxSize {
+			break
+		}
+
+		points, err := data.PbDecodePoints(msg)
+		if err != nil {
+			break
+		}
+
+		ne := data.NodeEdge{ID: chunks[1]}
+		if len(chunks) == 3 {
+			ne.Parent = chunks[2]
+			ne.EdgePoints = points
+		} else {
+			ne.Points = points
+		}
+
+		msgs = append(msgs, ne)
+		size += len(subject) + len(msg)
+	}
+
+	return msgs, len(msgs), size
+}
+
+// drain sends queued points to the remote in order until the context is
+// canceled. Consecutive node and edge point messages are combined into one
+// point batch request, compressed if compress is set. Each request is
+// acknowledged by the remote before its messages are removed from the queue,
+// and draining stops at the first failed request. Messages older than the
+// queueAge point are dropped.
+func (up *SyncClient) drain(ctx context.Context, nc *nats.Conn, maxAge time.Duration,
+	compress bool, done chan struct{}) {
+	defer close(done)
+
+	attempts := 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	// point batches are not used if the remote does not support them
+	batchOK := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		delay := syncDrainPeriod
+
+	drainLoop:
+		for ctx.Err() == nil {
+			records, seq, err := up.queue.Peek(syncDrainBatch)
+			if err != nil {
+				log.Println("Sync: error reading queue:", err)
+				break
+			}
+
+			if len(records) <= 0 {
+				break
+			}
+
+			for i := 0; i < len(records); {
+				t, subject, msg, err := decodeSyncRecord(records[i])
+				if err == nil && maxAge > 0 && time.Since(t) > maxAge {
+					err = errors.New("expired")
+				}
+
+				if err != nil {
+					// drop bad or expired records
+					err := up.queue.Drop(seq+uint64(i), 1)
+					if err != nil {
+						log.Println("Sync: error dropping queued points:", err)
+						break drainLoop
+					}
+					i++
+					continue
+				}
+
+				n := 1
+				size := len(subject) + len(msg)
+
+				if batchOK {
+					// leave room for the batch encoding
+					msgs, count, msgsSize := syncRecordBatch(records[i:], maxAge,
+						int(nc.MaxPayload()/2))
+					if count > 1 {
+						batch, err := EncodePointBatch(msgs, compress)
+						if err != nil {
+							log.Println("Sync: error encoding point batch:", err)
+							break drainLoop
+						}
+						n, size = count, msgsSize
+						subject, msg = SubjectPointBatch(), batch
+					}
+				}
+
+				res, err := nc.Request(subject, msg, time.Second*5)
+				if n > 1 && errors.Is(err, nats.ErrNoResponders) {
+					log.Println("Sync: remote does not support point batches, " +
+						"sending queued points individually")
+					batchOK = false
+					continue
+				}
+
+				if err == nil && len(res.Data) > 0 {
+					// the remote could not process the points, so
+					// retrying will not help
+					log.Printf("Sync: remote rejected points on %v: %v\n",
+						subject, string(res.Data))
+					err = up.queue.Drop(seq+uint64(i), n)
+					if err != nil {
+						log.Println("Sync: error dropping queued points:", err)
+						break drainLoop
+					}
+					i += n
+					continue
+				}
+
+				if err != nil {
+					if attempts == 0 {
+						log.Println("Sync: error sending queued points, retrying:", err)
+					}
+					attempts++
+					delay = ExpBackoff(attempts, syncBackoffMax)
+					break drainLoop
+				}
+
+				attempts = 0
+				up.bytesSent.Add(int64(len(subject) + len(msg)))
+				if n > 1 {
+					up.bytesSaved.Add(int64(size - len(subject) - len(msg)))
+				}
+
+				err = up.queue.Pop(seq+uint64(i), n)
+				if err != nil {
+					log.Println("Sync: error removing points from queue:", err)
+					break drainLoop
+				}
+				i += n
+			}
+		}
+
+		timer.Reset(delay)
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestSyncRecordBatch(t *testing.T) {
+	now := time.Now()
+
+	record := func(t0 time.Time, subject string, points data.Points) []byte {
+		msg, err := points.ToPb()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encodeSyncRecord(t0, subject, msg)
+	}
+
+	v1 := data.Points{{Type: data.PointTypeValue, Value: 1}}
+	v2 := data.Points{{Type: data.PointTypeValue, Value: 2}}
+
+	records := [][]byte{
+		record(now, SubjectNodePoints("a"), v1),
+		record(now, SubjectNodePoints("a"), v2),
+		record(now, SubjectEdgePoints("a", "root"), data.Points{{Type: data.PointTypeTombstone}}),
+		encodeSyncRecord(now, SubjectPointBatch(), []byte{0}),
+		record(now, SubjectNodePoints("a"), v1),
+	}
+
+	msgs, n, size := syncRecordBatch(records, 0, 1000)
+
+	// batches stop at records that are not node or edge points, and
+	// points are not deduplicated
+	if n != 3 || len(msgs) != 3 || msgs[1].Points[0].Value != 2 ||
+		msgs[2].Parent != "root" || len(msgs[2].EdgePoints) != 1 {
+		t.Fatalf("wrong batch, n: %v, msgs: %+v", n, msgs)
+	}
+
+	if size <= 0 {
+		t.Error("expected batch size, got: ", size)
+	}
+
+	_, n, _ = syncRecordBatch(records, 0, size-1)
+	if n != 2 {
+		t.Error("size limit not applied, n: ", n)
+	}
+
+	records[1] = record(now.Add(-time.Hour), SubjectNodePoints("a"), v2)
+	_, n, _ = syncRecordBatch(records, time.Minute, 1000)
+	if n != 1 {
+		t.Error("batch should stop at expired record, n: ", n)
+	}
+}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -21,6 +23,46 @@ type Sync struct {
 	Disabled       bool   `point:"disabled"`
 	SyncCount      int    `point:"syncCount"`
 	SyncCountReset bool   `point:"syncCountReset"`
+	// BackupURIs are used in order if the connection to URI fails
+	// FailoverCount times. While connected to a backup, higher priority
+	// URIs are checked every FailbackPeriod seconds. ActiveURI is the URI
+	// in use.
+	BackupURIs     []string `point:"backupUri"`
+	FailoverCount  int      `point:"failoverCount"`
+	FailbackPeriod int      `point:"failbackPeriod"`
+	ActiveURI      string   `point:"activeUri"`
+	// QueueSize is the max number of point messages queued while
+	// disconnected, and QueueAge is the max age of queued messages in
+	// seconds (0 for no limit)
+	QueueSize    int `point:"queueSize"`
+	QueueAge     int `point:"queueAge"`
+	QueueDepth   int `point:"queueDepth"`
+	QueueDropped int `point:"queueDropped"`
+	// Include and Exclude filters select the nodes and point types that
+	// are synced. Excluding a node excludes its subtree.
+	IncludeNodeTypes  []string `point:"includeNodeType"`
+	ExcludeNodeTypes  []string `point:"excludeNodeType"`
+	IncludeNodes      []string `point:"includeNode"`
+	ExcludeNodes      []string `point:"excludeNode"`
+	IncludePointTypes []string `point:"includePointType"`
+	ExcludePointTypes []string `point:"excludePointType"`
+	// BatchWindow is the time in milliseconds that points are collected
+	// before they are sent upstream in one message (0 disables batching).
+	// If Compress is set, batches are compressed.
+	BatchWindow int  `point:"batchWindow"`
+	Compress    bool `point:"compress"`
+	BytesSent   int  `point:"bytesSent"`
+	BytesSaved  int  `point:"bytesSaved"`
+	// status points reported by the client. LastSync is the time
+	// (RFC3339) of the last successful sync, Latency is the round trip time
+	// to the upstream in ms, and OutOfSync is the number of nodes that were
+	// synced in the last pass.
+	Connected     bool    `point:"connected"`
+	LastSync      string  `point:"lastSync"`
+	Error         string  `point:"error"`
+	Latency       float64 `point:"latency"`
+	OutOfSync     int     `point:"outOfSync"`
+	BytesReceived int     `point:"bytesReceived"`
 }
 
 type newEdge struct {
@@ -43,9 +85,39 @@ type SyncClient struct {
 	subRemoteNodePoints map[string]*nats.Subscription
 	subRemoteEdgePoints map[string]*nats.Subscription
 	subRemoteUp         *nats.Subscription
-	chConnected         chan bool
+	chConnected         chan connState
 	initialSub          bool
 	chNewEdge           chan newEdge
+	// queue holds local points while the remote is not connected
+	queue  *spool
+	filter *syncFilter
+	// bytesSent and bytesSaved are running totals of point message bytes
+	// sent upstream, and saved by batching
+	bytesSent     atomic.Int64
+	bytesSaved    atomic.Int64
+	bytesReceived atomic.Int64
+	// outOfSync counts the nodes synced during a sync pass
+	outOfSync int
+	chDiff    chan *nats.Msg
+	// uriIndex is the index of the URI in use, 0 is the primary URI.
+	// connGen is incremented on each connection so that connect failures
+	// from a previous connection are ignored.
+	uriIndex        int
+	connGen         int
+	chConnectFailed chan connectFailed
+	chFailback      chan int
+}
+
+type connectFailed struct {
+	gen      int
+	attempts int
+}
+
+// connState is sent by the connection callbacks. gen is the connection
+// generation, so events from a closed connection can be ignored.
+type connState struct {
+	gen       int
+	connected bool
 }
 
 // NewSyncClient constructor
@@ -56,10 +128,14 @@ func NewSyncClient(nc *nats.Conn, config Sync) Client {
 		stop:                make(chan struct{}),
 		newPoints:           make(chan NewPoints),
 		newEdgePoints:       make(chan NewPoints),
-		chConnected:         make(chan bool),
+		chConnected:         make(chan connState),
 		subRemoteNodePoints: make(map[string]*nats.Subscription),
 		subRemoteEdgePoints: make(map[string]*nats.Subscription),
 		chNewEdge:           make(chan newEdge),
+		filter:              newSyncFilter(config),
+		chDiff:              make(chan *nats.Msg),
+		chConnectFailed:     make(chan connectFailed, 1),
+		chFailback:          make(chan int, 1),
 	}
 }
 
@@ -95,6 +171,11 @@ func (up *SyncClient) Run() error {
 		return fmt.Errorf("Error connection to local NATS: %v", err)
 	}
 
+	up.queue, err = newSpool(spoolPath("sync-"+up.config.ID), up.queueSize())
+	if err != nil {
+		return fmt.Errorf("Error opening sync queue: %v", err)
+	}
+
 	chLocalNodePoints := make(chan NewPoints)
 	chLocalEdgePoints := make(chan NewPoints)
 
@@ -113,6 +194,13 @@ func (up *SyncClient) Run() error {
 		log.Println("SyncClient: error subscribing:", err)
 	}
 
+	subDiff, err := up.nc.Subscribe(SubjectSyncDiff(up.config.ID), func(msg *nats.Msg) {
+		up.chDiff <- msg
+	})
+	if err != nil {
+		log.Println("SyncClient: error subscribing:", err)
+	}
+
 	subLocalEdgePoints, err := up.ncLocal.Subscribe(SubjectEdgeAllPoints(), func(msg *nats.Msg) {
 		nodeID, parentID, points, err := DecodeEdgePointsMsg(msg)
 
@@ -160,9 +248,194 @@ func (up *SyncClient) Run() error {
 		return fmt.Errorf("Error getting root node: %v", err)
 	}
 
+	err = up.filter.Update(up.nc, up.rootLocal)
+	if err != nil {
+		log.Println("Sync: error updating filter:", err)
+	}
+
 	connected := false
 	up.initialSub = false
 
+	stats := newSpoolStats(up.nc, up.config.ID, up.config.QueueDropped)
+	stats.report(up.queue)
+
+	up.bytesSent.Store(int64(up.config.BytesSent))
+	up.bytesSaved.Store(int64(up.config.BytesSaved))
+	up.bytesReceived.Store(int64(up.config.BytesReceived))
+	lastBytesSent, lastBytesSaved := up.bytesSent.Load(), up.bytesSaved.Load()
+	lastBytesReceived := up.bytesReceived.Load()
+
+	reportBytes := func() {
+		sent, saved := up.bytesSent.Load(), up.bytesSaved.Load()
+		received := up.bytesReceived.Load()
+		if sent == lastBytesSent && saved == lastBytesSaved &&
+			received == lastBytesReceived {
+			return
+		}
+
+		err := SendNodePoints(up.nc, up.config.ID, data.Points{
+			{Time: time.Now(), Type: data.PointTypeBytesSent, Value: float64(sent)},
+			{Time: time.Now(), Type: data.PointTypeBytesSaved, Value: float64(saved)},
+			{Time: time.Now(), Type: data.PointTypeBytesReceived, Value: float64(received)},
+		}, false)
+		if err != nil {
+			log.Println("Error sending sync byte counts:", err)
+			return
+		}
+
+		lastBytesSent, lastBytesSaved = sent, saved
+		lastBytesReceived = received
+	}
+
+	if up.config.Connected {
+		// clear the state from the last run
+		up.sendStatus(data.Point{Type: data.PointTypeConnected, Value: 0})
+	}
+
+	statsTicker := time.NewTicker(syncStatsPeriod)
+	defer statsTicker.Stop()
+
+	var drainCancel context.CancelFunc
+	var drainDone chan struct{}
+
+	startDrain := func() {
+		if drainCancel != nil || up.ncRemote == nil {
+			return
+		}
+		var ctx context.Context
+		ctx, drainCancel = context.WithCancel(context.Background())
+		drainDone = make(chan struct{})
+		go up.drain(ctx, up.ncRemote, time.Duration(up.config.QueueAge)*time.Second,
+			up.config.Compress, drainDone)
+	}
+
+	stopDrain := func() {
+		if drainCancel == nil {
+			return
+		}
+		drainCancel()
+		<-drainDone
+		drainCancel = nil
+	}
+
+	// sendMsg sends a message to the remote. Messages are queued if we are
+	// not connected, or there are older messages in the queue.
+	sendMsg := func(subject string, msg []byte) {
+		if connected && up.queue.Len() <= 0 {
+			err := up.ncRemote.Publish(subject, msg)
+			if err == nil {
+				up.bytesSent.Add(int64(len(subject) + len(msg)))
+				return
+			}
+			log.Println("Error sending points to remote system, queuing:", err)
+		}
+
+		up.queueMsg(subject, msg)
+	}
+
+	failbackTicker := time.NewTicker(up.failbackPeriod())
+	defer failbackTicker.Stop()
+	probing := false
+
+	// setDisconnected stops using the remote connection. Points are queued
+	// until the connection is up again.
+	setDisconnected := func() {
+		if connected {
+			up.sendConnectEvent(false)
+			up.sendStatus(data.Point{Type: data.PointTypeConnected, Value: 0})
+			stats.report(up.queue)
+		}
+		connected = false
+		stopDrain()
+		syncTicker.Stop()
+		// the following is required in case a new server
+		// is set up which may have a new root
+		up.rootRemote = data.NodeEdge{}
+	}
+
+	// switchURI restarts the connection using another upstream URI. Events
+	// from the old connection are ignored as the connection generation
+	// changes.
+	switchURI := func(i int) {
+		setDisconnected()
+		up.uriIndex = i
+		up.disconnect()
+		connectTimer.Reset(10 * time.Millisecond)
+	}
+
+	batch := newSyncBatch()
+	batchTimer := time.NewTimer(time.Hour)
+	batchTimer.Stop()
+
+	flushBatch := func() {
+		batchTimer.Stop()
+		if batch.len() <= 0 {
+			return
+		}
+
+		msgs, size := batch.take()
+		msg, err := EncodePointBatch(msgs, up.config.Compress)
+		if err != nil {
+			log.Println("Sync: error encoding point batch:", err)
+			return
+		}
+
+		subject := SubjectPointBatch()
+		up.bytesSaved.Add(int64(size - len(subject) - len(msg)))
+		sendMsg(subject, msg)
+	}
+
+	// sendLocal sends local node points, or edge points if parent is set,
+	// to the remote. If queue is not set, points are sent only if connected
+	// and are not batched.
+	sendLocal := func(id, parent string, points data.Points, queue bool) {
+		if up.config.Disabled {
+			return
+		}
+
+		for i := range points {
+			if points[i].Time.IsZero() {
+				points[i].Time = time.Now()
+			}
+		}
+
+		subject := SubjectNodePoints(id)
+		if parent != "" {
+			subject = SubjectEdgePoints(id, parent)
+		}
+
+		msg, err := points.ToPb()
+		if err != nil {
+			log.Println("Sync: error encoding points:", err)
+			return
+		}
+
+		if !queue {
+			if !connected {
+				return
+			}
+			err := up.ncRemote.Publish(subject, msg)
+			if err != nil {
+				log.Println("Error sending points to remote system:", err)
+			}
+			return
+		}
+
+		if up.config.BatchWindow > 0 {
+			if batch.len() <= 0 {
+				batchTimer.Reset(time.Duration(up.config.BatchWindow) *
+					time.Millisecond)
+			}
+			batch.add(id, parent, points, len(subject)+len(msg))
+			if batch.len() >= syncBatchMax {
+				flushBatch()
+			}
+			return
+		}
+
+		sendMsg(subject, msg)
+	}
+
 done:
 	for {
 		select {
@@ -174,52 +447,104 @@ done:
 			if err != nil {
 				log.Printf("Sync connect failure: %v: %v\n",
 					up.config.Description, err)
+				if err.Error() != up.config.Error {
+					up.config.Error = err.Error()
+					up.sendStatus(data.Point{Type: data.PointTypeError,
+						Text: up.config.Error})
+				}
 				connectTimer.Reset(30 * time.Second)
 			}
 		case <-syncTicker.C:
-			err := up.syncNode("root", up.rootLocal.ID)
-			if err != nil {
-				log.Println("Error syncing:", err)
+			if !connected {
+				// tick from before the ticker was stopped
+				break
+			}
+			up.syncPass()
+		case f := <-up.chConnectFailed:
+			uris := up.uris()
+			if f.gen != up.connGen || connected || len(uris) < 2 ||
+				f.attempts < up.failoverCount() {
+				break
+			}
+			next := (up.uriIndex + 1) % len(uris)
+			log.Printf("Sync: %v: failing over from %v to %v\n",
+				up.config.Description, uris[up.uriIndex], uris[next])
+			switchURI(next)
+		case <-failbackTicker.C:
+			if up.uriIndex == 0 || probing || up.config.Disabled {
+				break
+			}
+			// check if a higher priority upstream is available
+			probing = true
+			uris := up.uris()[:up.uriIndex]
+			token := up.config.AuthToken
+			go func() {
+				up.chFailback <- probeURIs(uris, token)
+			}()
+		case i := <-up.chFailback:
+			probing = false
+			if i < 0 || i >= up.uriIndex {
+				break
+			}
+			uris := up.uris()
+			log.Printf("Sync: %v: switching back from %v to %v\n",
+				up.config.Description, uris[up.uriIndex], uris[i])
+			switchURI(i)
+
+		case <-statsTicker.C:
+			stats.report(up.queue)
+			reportBytes()
+		case <-batchTimer.C:
+			flushBatch()
+		case msg := <-up.chDiff:
+			up.handleDiff(msg, connected)
+		case cs := <-up.chConnected:
+			if cs.gen != up.connGen {
+				// event from a connection that was closed
+				break
 			}
 
-		case conn := <-up.chConnected:
-			connected = conn
-			if conn {
-				syncTicker.Reset(time.Duration(up.config.Period) * time.Second)
-				err := up.syncNode("root", up.rootLocal.ID)
-				if err != nil {
-					log.Println("Error syncing:", err)
-				}
+			if !cs.connected {
+				setDisconnected()
+				break
+			}
 
-				if !up.initialSub {
-					// set up initial subscriptions to remote nodes
-					err = up.subscribeRemoteNode(up.rootLocal.Parent, up.rootLocal.ID)
-					if err != nil {
-						log.Println("Sync: initial sub failed:", err)
-					} else {
-						up.initialSub = true
-					}
-				}
-			} else {
-				syncTicker.Stop()
-				// the following is required in case a new server
-				// is set up which may have a new root
-				up.rootRemote = data.NodeEdge{}
+			if !connected {
+				up.sendConnectEvent(true)
+				up.sendStatus(data.Point{Type: data.PointTypeConnected, Value: 1})
+				stats.report(up.queue)
 			}
-		case pts := <-chLocalNodePoints:
-			if connected {
-				err = SendNodePoints(up.ncRemote, pts.ID, pts.Points, false)
+			connected = true
+			startDrain()
+			syncTicker.Reset(time.Duration(up.config.Period) * time.Second)
+			up.syncPass()
+
+			if !up.initialSub {
+				// set up initial subscriptions to remote nodes
+				err = up.subscribeRemoteNode(up.rootLocal.Parent, up.rootLocal.ID)
 				if err != nil {
-					log.Println("Error sending node points to remote system:", err)
+					log.Println("Sync: initial sub failed:", err)
+				} else {
+					up.initialSub = true
 				}
 			}
+		case pts := <-chLocalNodePoints:
+			points := up.filter.LocalNodePoints(up.nc, pts.ID, pts.Points)
+			if len(points) <= 0 {
+				break
+			}
+			// points for the sync node (queue stats, etc) are not
+			// queued as they are reconciled by the hash sync
+			sendLocal(pts.ID, "", points, pts.ID != up.config.ID)
 		case pts := <-chLocalEdgePoints:
-			if connected {
-				err = SendEdgePoints(up.ncRemote, pts.ID, pts.Parent, pts.Points, false)
-				if err != nil {
-					log.Println("Error sending edge points to remote system:", err)
-				}
+			synced, nodePoints := up.filter.LocalEdgePoints(pts.ID, pts.Parent, pts.Points)
+			if !synced {
+				break
+			}
+			if len(nodePoints) > 0 {
+				sendLocal(pts.ID, "", nodePoints, true)
 			}
+			sendLocal(pts.ID, pts.Parent, pts.Points, true)
 		case pts := <-up.newPoints:
 			err := data.MergePoints(pts.ID, pts.Points, &up.config)
 			if err != nil {
@@ -229,11 +554,47 @@ done:
 			for _, p := range pts.Points {
 				switch p.Type {
 				case data.PointTypeURI,
+					data.PointTypeBackupURI,
 					data.PointTypeAuthToken,
 					data.PointTypeDisabled:
-					// we need to restart the sync connection
-					up.disconnect()
-					connectTimer.Reset(10 * time.Millisecond)
+					// we need to restart the sync connection, starting
+					// with the primary URI
+					switchURI(0)
+				case data.PointTypeFailbackPeriod:
+					failbackTicker.Reset(up.failbackPeriod())
+				case data.PointTypeQueueSize:
+					up.queue.SetMax(up.queueSize())
+				case data.PointTypeQueueAge:
+					if connected {
+						stopDrain()
+						startDrain()
+					}
+				case data.PointTypeQueueDropped:
+					stats.setDropped(up.config.QueueDropped, up.queue)
+				case data.PointTypeBatchWindow:
+					if up.config.BatchWindow <= 0 {
+						flushBatch()
+					}
+				case data.PointTypeBytesSent:
+					up.bytesSent.Store(int64(up.config.BytesSent))
+					lastBytesSent = up.bytesSent.Load()
+				case data.PointTypeBytesSaved:
+					up.bytesSaved.Store(int64(up.config.BytesSaved))
+					lastBytesSaved = up.bytesSaved.Load()
+				case data.PointTypeBytesReceived:
+					up.bytesReceived.Store(int64(up.config.BytesReceived))
+					lastBytesReceived = up.bytesReceived.Load()
+				case data.PointTypeIncludeNodeType,
+					data.PointTypeExcludeNodeType,
+					data.PointTypeIncludeNode,
+					data.PointTypeExcludeNode,
+					data.PointTypeIncludePointType,
+					data.PointTypeExcludePointType:
+					up.filter.SetConfig(up.config)
+					err := up.filter.Update(up.nc, up.rootLocal)
+					if err != nil {
+						log.Println("Sync: error updating filter:", err)
+					}
 				case data.PointTypePeriod:
 					checkPeriod()
 					if connected {
@@ -264,6 +625,8 @@ done:
 				log.Println("error merging new points:", err)
 			}
 		case edge := <-up.chNewEdge:
+			// set if the new remote node is not synced
+			filtered := false
 			if !edge.local {
 				// a new remote node was created, if it does not exist here,
 				// create it
@@ -298,13 +661,26 @@ done:
 					if n.Type == "" {
 						goto fetchAgain
 					}
-					err := up.sendNodesLocal(n)
+					synced, err := up.filter.RemoteSynced(up.ncRemote, n)
+					if err != nil {
+						log.Println("Error checking upstream node filter:", err)
+						continue
+					}
+					if !synced {
+						filtered = true
+						continue
+					}
+					err = up.sendNodesLocal(n)
 					if err != nil {
 						log.Println("Error chNewEdge sendNodesLocal:", err)
 					}
 				}
 			}
 
+			if filtered || (edge.local && !up.filter.Synced(edge.id)) {
+				break
+			}
+
 			err = up.subscribeRemoteNode(edge.parent, edge.id)
 			if err != nil {
 				log.Println("Error subscribing to new edge:", err)
@@ -323,10 +699,84 @@ done:
 		log.Println("Error unsubscribing edge points from local bus:", err)
 	}
 
+	err = subDiff.Unsubscribe()
+	if err != nil {
+		log.Println("Error unsubscribing from sync diff:", err)
+	}
+
+	// any batched points are sent or queued before we exit
+	flushBatch()
+	stopDrain()
+	if connected {
+		up.sendStatus(data.Point{Type: data.PointTypeConnected, Value: 0})
+	}
 	up.disconnect()
 	up.ncLocal.Close()
 
-	return nil
+	return up.queue.Close()
+}
+
+// uris returns the primary and backup upstream URIs in priority order
+func (up *SyncClient) uris() []string {
+	ret := []string{up.config.URI}
+	for _, u := range up.config.BackupURIs {
+		if u != "" {
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
+
+// uri returns the upstream URI currently in use
+func (up *SyncClient) uri() string {
+	uris := up.uris()
+	if up.uriIndex >= len(uris) {
+		up.uriIndex = 0
+	}
+	return uris[up.uriIndex]
+}
+
+func (up *SyncClient) failoverCount() int {
+	if up.config.FailoverCount > 0 {
+		return up.config.FailoverCount
+	}
+	return syncFailoverCount
+}
+
+func (up *SyncClient) failbackPeriod() time.Duration {
+	if up.config.FailbackPeriod > 0 {
+		return time.Duration(up.config.FailbackPeriod) * time.Second
+	}
+	return syncFailbackPeriod
+}
+
+// probeURIs returns the index of the first URI that accepts a connection,
+// or -1 if none do
+func probeURIs(uris []string, token string) int {
+	for i, u := range uris {
+		uri, err := sanitizeURI(u)
+		if err != nil {
+			continue
+		}
+
+		nc, err := nats.Connect(uri, nats.Token(token), nats.Timeout(5*time.Second),
+			nats.NoReconnect())
+		if err != nil {
+			continue
+		}
+
+		nc.Close()
+		return i
+	}
+
+	return -1
+}
+
+func (up *SyncClient) queueSize() int {
+	if up.config.QueueSize > 0 {
+		return up.config.QueueSize
+	}
+	return syncQueueSize
 }
 
 // Stop sends a signal to the Run function to exit
@@ -346,32 +796,124 @@ func (up *SyncClient) EdgePoints(nodeID, parentID string, points []data.Point) {
 	up.newEdgePoints <- NewPoints{nodeID, parentID, points}
 }
 
+func (up *SyncClient) sendConnectEvent(connected bool) {
+	e := data.Event{
+		Type:    data.EventTypeSyncConnect,
+		Level:   data.EventLevelInfo,
+		Message: "Sync connected to " + up.config.ActiveURI,
+	}
+
+	if !connected {
+		e.Type = data.EventTypeSyncDisconnect
+		e.Message = "Sync disconnected from " + up.config.ActiveURI
+	}
+
+	err := SendEvent(up.nc, up.config.ID, e)
+	if err != nil {
+		log.Println("Error sending sync event:", err)
+	}
+}
+
+// sendStatus sends status points to the sync node
+func (up *SyncClient) sendStatus(points ...data.Point) {
+	for i := range points {
+		points[i].Time = time.Now()
+	}
+
+	err := SendNodePoints(up.nc, up.config.ID, points, false)
+	if err != nil {
+		log.Println("Error sending sync status:", err)
+	}
+}
+
+// syncPass syncs the local tree with the upstream and reports the result
+// as status points
+func (up *SyncClient) syncPass() {
+	up.outOfSync = 0
+
+	var points data.Points
+
+	rtt, err := up.ncRemote.RTT()
+	if err == nil {
+		points = append(points, data.Point{Type: data.PointTypeLatency,
+			Value: float64(rtt.Microseconds()) / 1000})
+	}
+
+	if err == nil {
+		err = up.syncNode("root", up.rootLocal.ID)
+	}
+
+	if err != nil {
+		log.Println("Error syncing:", err)
+		if err.Error() != up.config.Error {
+			up.config.Error = err.Error()
+			points = append(points, data.Point{Type: data.PointTypeError,
+				Text: up.config.Error})
+		}
+		up.sendStatus(points...)
+		return
+	}
+
+	points = append(points,
+		data.Point{Type: data.PointTypeLastSync, Text: time.Now().Format(time.RFC3339)})
+
+	if up.outOfSync != up.config.OutOfSync {
+		up.config.OutOfSync = up.outOfSync
+		points = append(points, data.Point{Type: data.PointTypeOutOfSync,
+			Value: float64(up.outOfSync)})
+	}
+
+	if up.config.Error != "" {
+		up.config.Error = ""
+		points = append(points, data.Point{Type: data.PointTypeError})
+	}
+
+	up.sendStatus(points...)
+}
+
 func (up *SyncClient) connect() error {
 	if up.config.Disabled {
 		log.Printf("Sync %v disabled", up.config.Description)
 		return nil
 	}
 
+	uri := up.uri()
+	if uri != up.config.ActiveURI {
+		up.config.ActiveURI = uri
+		up.sendStatus(data.Point{Type: data.PointTypeActiveURI, Text: uri})
+	}
+
+	up.connGen++
+	gen := up.connGen
+
 	opts := EdgeOptions{
-		URI:       up.config.URI,
+		URI:       uri,
 		AuthToken: up.config.AuthToken,
 		NoEcho:    true,
 		Connected: func() {
-			up.chConnected <- true
+			up.chConnected <- connState{gen: gen, connected: true}
 			log.Printf("Sync: %v: Remote Connected: %v\n",
-				up.config.Description, up.config.URI)
+				up.config.Description, uri)
 		},
 		Disconnected: func() {
-			up.chConnected <- false
+			up.chConnected <- connState{gen: gen, connected: false}
 			log.Printf("Sync: %v: Remote Disconnected\n", up.config.Description)
 		},
 		Reconnected: func() {
-			up.chConnected <- true
+			up.chConnected <- connState{gen: gen, connected: true}
 			log.Printf("Sync: %v: Remote Reconnected\n", up.config.Description)
 		},
 		Closed: func() {
 			log.Printf("Sync: %v: Remote Closed\n", up.config.Description)
 		},
+		ConnectFailed: func(attempts int) {
+			// don't block the NATS reconnect loop, the attempts count
+			// is sent again on the next failure
+			select {
+			case up.chConnectFailed <- connectFailed{gen: gen, attempts: attempts}:
+			default:
+			}
+		},
 	}
 
 	var err error
@@ -388,12 +930,19 @@ func (up *SyncClient) subscribeRemoteNodePoints(id string) error {
 	if _, ok := up.subRemoteNodePoints[id]; !ok {
 		var err error
 		up.subRemoteNodePoints[id], err = up.ncRemote.Subscribe(SubjectNodePoints(id), func(msg *nats.Msg) {
+			up.bytesReceived.Add(int64(len(msg.Subject) + len(msg.Data)))
+
 			nodeID, points, err := DecodeNodePointsMsg(msg)
 			if err != nil {
 				log.Println("Error decoding point:", err)
 				return
 			}
 
+			points = up.filter.Points(points)
+			if len(points) <= 0 {
+				return
+			}
+
 			err = SendNodePoints(up.ncLocal, nodeID, points, false)
 			if err != nil {
 				log.Println("Error sending node points to remote system:", err)
@@ -414,6 +963,8 @@ func (up *SyncClient) subscribeRemoteEdgePoints(parent, id string) error {
 		key := id + ":" + parent
 		up.subRemoteEdgePoints[key], err = up.ncRemote.Subscribe(SubjectEdgePoints(id, parent),
 			func(msg *nats.Msg) {
+				up.bytesReceived.Add(int64(len(msg.Subject) + len(msg.Data)))
+
 				nodeID, parentID, points, err := DecodeEdgePointsMsg(msg)
 				if err != nil {
 					log.Println("Error decoding point:", err)
@@ -451,6 +1002,10 @@ func (up *SyncClient) subscribeRemoteNode(parent, id string) error {
 	}
 
 	for _, c := range children {
+		if !up.filter.Synced(c.ID) {
+			continue
+		}
+
 		err := up.subscribeRemoteNode(c.Parent, c.ID)
 		if err != nil {
 			return err
@@ -501,6 +1056,8 @@ func (up *SyncClient) sendNodesRemote(node data.NodeEdge) error {
 		node.Parent = up.rootRemote.ID
 	}
 
+	node.Points = up.filter.Points(node.Points)
+
 	err := SendNode(up.ncRemote, node, up.config.ID)
 	if err != nil {
 		return err
@@ -513,6 +1070,10 @@ func (up *SyncClient) sendNodesRemote(node data.NodeEdge) error {
 	}
 
 	for _, childNode := range childNodes {
+		if !up.filter.Synced(childNode.ID) {
+			continue
+		}
+
 		err := up.sendNodesRemote(childNode)
 
 		if err != nil {
@@ -549,6 +1110,59 @@ func (up *SyncClient) sendNodesLocal(node data.NodeEdge) error {
 	return nil
 }
 
+// syncHashes returns the hashes of a local node and its upstream copy that
+// are compared to determine if the node is in sync
+func (up *SyncClient) syncHashes(nodeLocal, nodeUp data.NodeEdge) (uint32, uint32, error) {
+	if nodeLocal.ID == up.rootLocal.ID {
+		// we need to back out the edge points from the hash as don't want to sync those
+		for _, p := range nodeUp.EdgePoints {
+			nodeUp.Hash ^= p.CRC()
+		}
+
+		for _, p := range nodeLocal.EdgePoints {
+			nodeLocal.Hash ^= p.CRC()
+		}
+	}
+
+	if up.filter.Active() {
+		// compare the hashes of what is synced so that points and nodes
+		// that are filtered don't cause a mismatch
+		if h, ok := up.filter.Hash(nodeLocal); ok {
+			nodeLocal.Hash = h
+		}
+
+		if nodeUp.Hash != nodeLocal.Hash {
+			// the upstream may still have nodes or points that
+			// were synced before the filter was set
+			var err error
+			nodeUp.Hash, err = up.filter.RemoteHash(up.ncRemote, nodeUp,
+				nodeLocal.ID == up.rootLocal.ID)
+			if err != nil {
+				return 0, 0, fmt.Errorf("Error getting upstream filtered hash: %v", err)
+			}
+		}
+	}
+
+	return nodeLocal.Hash, nodeUp.Hash, nil
+}
+
+// pointsHash returns the hash of the synced points of a node, not including
+// its children
+func (up *SyncClient) pointsHash(n data.NodeEdge, edge bool) uint32 {
+	var ret uint32
+	for _, p := range up.filter.Points(n.Points) {
+		ret ^= p.CRC()
+	}
+
+	if edge {
+		for _, p := range n.EdgePoints {
+			ret ^= p.CRC()
+		}
+	}
+
+	return ret
+}
+
 func (up *SyncClient) syncNode(parent, id string) error {
 	var err error
 	if up.rootRemote.ID == "" {
@@ -598,6 +1212,14 @@ func (up *SyncClient) syncNode(parent, id string) error {
 
 	nodeLocal := nodeLocals[0]
 
+	if nodeLocal.ID == up.rootLocal.ID && up.filter.Active() {
+		// refresh which local nodes are synced and their hashes
+		err := up.filter.Update(up.nc, nodeLocal)
+		if err != nil {
+			return fmt.Errorf("Error updating sync filter: %v", err)
+		}
+	}
+
 	nodeUps, upErr := GetNodes(up.ncRemote, parent, id, "", true)
 	if upErr != nil {
 		if upErr != data.ErrDocumentNotFound {
@@ -626,6 +1248,7 @@ func (up *SyncClient) syncNode(parent, id string) error {
 		// restore a node on the upstream
 		// update the local tombstone timestamp so it is newer than the remote tombstone timestamp
 		log.Printf("Sync: undeleting remote node: %v:%v\n", nodeUp.Parent, nodeUp.ID)
+		up.outOfSync++
 		pTS := data.Point{Time: time.Now(), Type: data.PointTypeTombstone, Value: 0}
 		err := SendEdgePoint(up.ncRemote, nodeUp.ID, nodeUp.Parent, pTS, true)
 		if err != nil {
@@ -638,6 +1261,7 @@ func (up *SyncClient) syncNode(parent, id string) error {
 
 	if !nodeFound {
 		log.Printf("Sync node %v does not exist, sending\n", nodeLocal.Desc())
+		up.outOfSync++
 		err := up.sendNodesRemote(nodeLocal)
 		if err != nil {
 			return fmt.Errorf("Error sending node upstream: %w", err)
@@ -653,15 +1277,9 @@ func (up *SyncClient) syncNode(parent, id string) error {
 
 	nodeUp = nodeUps[0]
 
-	if nodeLocal.ID == up.rootLocal.ID {
-		// we need to back out the edge points from the hash as don't want to sync those
-		for _, p := range nodeUp.EdgePoints {
-			nodeUp.Hash ^= p.CRC()
-		}
-
-		for _, p := range nodeLocal.EdgePoints {
-			nodeLocal.Hash ^= p.CRC()
-		}
+	nodeLocal.Hash, nodeUp.Hash, err = up.syncHashes(nodeLocal, nodeUp)
+	if err != nil {
+		return err
 	}
 
 	if nodeUp.Hash == nodeLocal.Hash {
@@ -687,11 +1305,22 @@ func (up *SyncClient) syncNode(parent, id string) error {
 		nodeLocal.Desc(),
 		nodeUp.Hash, nodeLocal.Hash)
 
+	// the hash may also differ because of child nodes, so only count
+	// the node if its own points differ
+	root := nodeLocal.ID == up.rootLocal.ID
+	if up.pointsHash(nodeLocal, !root) != up.pointsHash(nodeUp, !root) {
+		up.outOfSync++
+	}
+
 	// first compare node points
 	// key in below map is the index of the point in the upstream node
 	upstreamProcessed := make(map[int]bool)
 
 	for _, p := range nodeLocal.Points {
+		if !up.filter.PointSynced(p.Type) {
+			continue
+		}
+
 		found := false
 		for i, pUp := range nodeUp.Points {
 			if p.IsMatch(pUp.Type, pUp.Key) {
@@ -723,6 +1352,10 @@ func (up *SyncClient) syncNode(parent, id string) error {
 
 	// check for any points that do not exist locally
 	for i, pUp := range nodeUp.Points {
+		if !up.filter.PointSynced(pUp.Type) {
+			continue
+		}
+
 		if _, ok := upstreamProcessed[i]; !ok {
 			err := SendNodePoint(up.nc, nodeLocal.ID, pUp, true)
 			if err != nil {
@@ -794,12 +1427,18 @@ func (up *SyncClient) syncNode(parent, id string) error {
 	upChildProcessed := make(map[int]bool)
 
 	for _, child := range children {
+		synced := up.filter.Synced(child.ID)
+		childHash := child.Hash
+		if h, ok := up.filter.Hash(child); ok {
+			childHash = h
+		}
+
 		found := false
 		for i, upChild := range upChildren {
 			if child.ID == upChild.ID {
 				found = true
 				upChildProcessed[i] = true
-				if child.Hash != upChild.Hash {
+				if synced && childHash != upChild.Hash {
 					err := up.syncNode(nodeLocal.ID, child.ID)
 					if err != nil {
 						fmt.Println("Error syncing node: ", err)
@@ -808,8 +1447,9 @@ func (up *SyncClient) syncNode(parent, id string) error {
 			}
 		}
 
-		if !found {
+		if !found && synced {
 			// need to send node upstream
+			up.outOfSync++
 			err := up.sendNodesRemote(child)
 			if err != nil {
 				log.Println("Error sending node upstream:", err)
@@ -824,7 +1464,18 @@ func (up *SyncClient) syncNode(parent, id string) error {
 
 	for i, upChild := range upChildren {
 		if _, ok := upChildProcessed[i]; !ok {
-			err := up.sendNodesLocal(upChild)
+			synced, err := up.filter.RemoteSynced(up.ncRemote, upChild)
+			if err != nil {
+				log.Println("Error checking upstream node filter:", err)
+				continue
+			}
+
+			if !synced {
+				continue
+			}
+
+			up.outOfSync++
+			err = up.sendNodesLocal(upChild)
 			if err != nil {
 				log.Println("Error getting node from upstream:", err)
 			}
@@ -2,15 +2,19 @@ package client_test
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/simpleiot/simpleiot/client"
 	"github.com/simpleiot/simpleiot/data"
 	"github.com/simpleiot/simpleiot/server"
 )
 
 func TestSync(t *testing.T) {
+	t.Setenv("SIOT_DATA", t.TempDir())
+
 	// Start up a SIOT test servers for this test
 	ncU, _, stopU, err := server.TestServer("2")
 
@@ -215,6 +219,7 @@ func TestSync(t *testing.T) {
 
 func TestSyncDeleteUpstream(t *testing.T) {
 	// if we delete the upstream node, the downstream sync process should re-create it
+	t.Setenv("SIOT_DATA", t.TempDir())
 
 	// Start up a SIOT test servers for this test
 	ncU, rootU, stopU, err := server.TestServer("2")
@@ -294,3 +299,707 @@ func TestSyncDeleteUpstream(t *testing.T) {
 		time.Sleep(time.Millisecond * 10)
 	}
 }
+
+func TestSyncQueue(t *testing.T) {
+	// points sent while the upstream is not reachable should be queued and
+	// sent in order once connected
+	t.Setenv("SIOT_DATA", t.TempDir())
+
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	var values []float64
+	var lock sync.Mutex
+
+	sub, err := ncU.Subscribe(client.SubjectNodePoints("varQueue"), func(msg *nats.Msg) {
+		_, points, err := client.DecodeNodePointsMsg(msg)
+		if err != nil {
+			t.Error("Error decoding points: ", err)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for _, p := range points {
+			if p.Type == data.PointTypeValue {
+				values = append(values, p.Value)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	// queued points are drained in point batches
+	batches := 0
+	subBatch, err := ncU.Subscribe(client.SubjectPointBatch(), func(_ *nats.Msg) {
+		lock.Lock()
+		defer lock.Unlock()
+		batches++
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer subBatch.Unsubscribe()
+
+	fmt.Println("**** create sync node with unreachable upstream")
+	syncNode := client.Sync{
+		ID:          "sync-id",
+		Parent:      rootD.ID,
+		Description: "sync to up",
+		URI:         "nats://localhost:1",
+	}
+
+	err = client.SendNodeType(ncD, syncNode, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	// wait for the sync client to start
+	time.Sleep(200 * time.Millisecond)
+
+	v := client.Variable{ID: "varQueue", Parent: rootD.ID, Description: "varQueue"}
+	err = client.SendNodeType(ncD, v, "test")
+	if err != nil {
+		t.Fatal("Error sending variable: ", err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		err = client.SendNodePoint(ncD, v.ID, data.Point{Type: data.PointTypeValue,
+			Value: float64(i)}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	// give the sync client time to queue the points
+	time.Sleep(100 * time.Millisecond)
+
+	fmt.Println("**** point sync to upstream")
+	// points without an origin on the sync node are ignored by the client
+	err = client.SendNodePoint(ncD, syncNode.ID, data.Point{Type: data.PointTypeURI,
+		Text: server.TestServerOptions2.NatsServer, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	start := time.Now()
+	for {
+		if time.Since(start) > 2*time.Second {
+			lock.Lock()
+			t.Fatal("queued points not received upstream: ", values)
+		}
+
+		// values must arrive in order, though the sync process may send
+		// the latest value earlier
+		lock.Lock()
+		next := 1.0
+		for _, v := range values {
+			if v == next {
+				next++
+			}
+		}
+		lock.Unlock()
+
+		if next > 5 {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// the queue depth is reported on connection
+	nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, syncNode.ID)
+	if err != nil {
+		t.Fatal("Error getting sync node: ", err)
+	}
+
+	if len(nodes) < 1 {
+		t.Fatal("sync node not found")
+	}
+
+	if nodes[0].QueueDepth < 5 {
+		t.Error("expected queued points, queue depth: ", nodes[0].QueueDepth)
+	}
+
+	if nodes[0].QueueDropped != 0 {
+		t.Error("expected no dropped points: ", nodes[0].QueueDropped)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if batches < 1 {
+		t.Error("queued points were not sent in a point batch")
+	}
+}
+
+func TestSyncFilter(t *testing.T) {
+	t.Setenv("SIOT_DATA", t.TempDir())
+
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	varSync := client.Variable{ID: "varSync", Parent: rootD.ID, Description: "varSync"}
+	varLocal := client.Variable{ID: "varLocal", Parent: rootD.ID, Description: "varLocal"}
+	grpLocal := client.Group{ID: "grpLocal", Parent: rootD.ID, Description: "grpLocal"}
+	varInGroup := client.Variable{ID: "varInGroup", Parent: grpLocal.ID, Description: "varInGroup"}
+
+	for _, err := range []error{
+		client.SendNodeType(ncD, varSync, "test"),
+		client.SendNodeType(ncD, varLocal, "test"),
+		client.SendNodeType(ncD, grpLocal, "test"),
+		client.SendNodeType(ncD, varInGroup, "test"),
+		client.SendNodePoint(ncD, varSync.ID, data.Point{Type: data.PointTypeUnits,
+			Text: "C"}, true),
+	} {
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	fmt.Println("**** create sync node")
+	syncNode := client.Sync{
+		ID:                "sync-id",
+		Parent:            rootD.ID,
+		Description:       "sync to up",
+		URI:               server.TestServerOptions2.NatsServer,
+		Period:            1,
+		ExcludeNodes:      []string{varLocal.ID},
+		ExcludeNodeTypes:  []string{data.NodeTypeGroup},
+		ExcludePointTypes: []string{data.PointTypeUnits},
+	}
+
+	err = client.SendNodeType(ncD, syncNode, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	getUp := func(id string) []data.NodeEdge {
+		nodes, err := client.GetNodes(ncU, "all", id, "", false)
+		if err != nil {
+			t.Fatal("Error getting upstream nodes: ", err)
+		}
+		return nodes
+	}
+
+	start := time.Now()
+	for len(getUp(varSync.ID)) <= 0 {
+		if time.Since(start) > time.Second {
+			t.Fatal("variable not synced")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	for _, id := range []string{varLocal.ID, grpLocal.ID, varInGroup.ID} {
+		if len(getUp(id)) > 0 {
+			t.Error("excluded node synced: ", id)
+		}
+	}
+
+	if _, ok := getUp(varSync.ID)[0].Points.Find(data.PointTypeUnits, ""); ok {
+		t.Error("excluded point type synced")
+	}
+
+	fmt.Println("**** send points to synced and excluded nodes")
+	err = client.SendNodePoint(ncD, varLocal.ID, data.Point{Type: data.PointTypeValue,
+		Value: 5}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	err = client.SendNodePoint(ncD, varSync.ID, data.Point{Type: data.PointTypeValue,
+		Value: 10}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	start = time.Now()
+	for {
+		if time.Since(start) > time.Second {
+			t.Fatal("point not synced")
+		}
+
+		if v, _ := getUp(varSync.ID)[0].Points.Value(data.PointTypeValue, ""); v == 10 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if len(getUp(varLocal.ID)) > 0 {
+		t.Error("excluded node synced after point change")
+	}
+
+	// the hash of the synced tree should match, so the sync count should
+	// not increase on every sync period
+	getCount := func() int {
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, syncNode.ID)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting sync node: ", err)
+		}
+		return nodes[0].SyncCount
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	count := getCount()
+	time.Sleep(2500 * time.Millisecond)
+	if getCount() != count {
+		t.Error("sync count increased, hash mismatch")
+	}
+}
+
+func TestSyncBatch(t *testing.T) {
+	t.Setenv("SIOT_DATA", t.TempDir())
+
+	ncU, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	v := client.Variable{ID: "varBatch", Parent: rootD.ID, Description: "varBatch"}
+	err = client.SendNodeType(ncD, v, "test")
+	if err != nil {
+		t.Fatal("Error sending variable: ", err)
+	}
+
+	fmt.Println("**** create sync node")
+	syncNode := client.Sync{
+		ID:          "sync-id",
+		Parent:      rootD.ID,
+		Description: "sync to up",
+		URI:         server.TestServerOptions2.NatsServer,
+		BatchWindow: 200,
+		Compress:    true,
+	}
+
+	err = client.SendNodeType(ncD, syncNode, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	start := time.Now()
+	for {
+		if time.Since(start) > time.Second {
+			t.Fatal("variable not synced")
+		}
+
+		nodes, err := client.GetNodes(ncU, "all", v.ID, "", false)
+		if err == nil && len(nodes) > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	batches := make(chan data.Nodes, 10)
+	sub, err := ncU.Subscribe(client.SubjectPointBatch(), func(msg *nats.Msg) {
+		msgs, err := client.DecodePointBatch(msg.Data)
+		if err != nil {
+			t.Error("Error decoding batch: ", err)
+			return
+		}
+		batches <- msgs
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	fmt.Println("**** send points")
+	for i := 1; i <= 20; i++ {
+		err = client.SendNodePoint(ncD, v.ID, data.Point{Type: data.PointTypeValue,
+			Value: float64(i)}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	select {
+	case msgs := <-batches:
+		if len(msgs) != 1 {
+			t.Fatal("expected 1 message in batch: ", msgs)
+		}
+		if len(msgs[0].Points) != 1 || msgs[0].Points[0].Value != 20 {
+			t.Error("points were not deduplicated: ", msgs[0].Points)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for batch")
+	}
+
+	start = time.Now()
+	for {
+		if time.Since(start) > time.Second {
+			t.Fatal("batched point not processed upstream")
+		}
+
+		nodes, err := client.GetNodes(ncU, "all", v.ID, "", false)
+		if err == nil && len(nodes) > 0 {
+			if value, _ := nodes[0].Points.Value(data.PointTypeValue, ""); value == 20 {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// byte counts are reported every 10s
+	start = time.Now()
+	for {
+		if time.Since(start) > 12*time.Second {
+			t.Fatal("byte counts not reported")
+		}
+
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, syncNode.ID)
+		if err == nil && len(nodes) > 0 && nodes[0].BytesSent > 0 &&
+			nodes[0].BytesSaved > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func TestSyncStatus(t *testing.T) {
+	t.Setenv("SIOT_DATA", t.TempDir())
+
+	_, _, stopU, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopU()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting upstream test server: ", err)
+	}
+
+	defer stopD()
+
+	v := client.Variable{ID: "varStatus", Parent: rootD.ID, Description: "varStatus"}
+	err = client.SendNodeType(ncD, v, "test")
+	if err != nil {
+		t.Fatal("Error sending variable: ", err)
+	}
+
+	// use a long batch window so local changes are not sent upstream
+	// during the test
+	syncNode := client.Sync{
+		ID:          "sync-id",
+		Parent:      rootD.ID,
+		Description: "sync to up",
+		URI:         server.TestServerOptions2.NatsServer,
+		Period:      60,
+		BatchWindow: 60000,
+	}
+
+	err = client.SendNodeType(ncD, syncNode, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	var s client.Sync
+	start := time.Now()
+	for {
+		if time.Since(start) > time.Second {
+			t.Fatal("sync status not reported: ", s)
+		}
+
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, syncNode.ID)
+		if err == nil && len(nodes) > 0 {
+			s = nodes[0]
+			if s.Connected && s.LastSync != "" {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if s.Error != "" {
+		t.Error("unexpected sync error: ", s.Error)
+	}
+
+	if s.OutOfSync != 1 {
+		t.Error("expected the root node to be out of sync: ", s.OutOfSync)
+	}
+
+	// wait for the status points to be forwarded upstream
+	time.Sleep(100 * time.Millisecond)
+
+	diff, err := client.GetSyncDiff(ncD, syncNode.ID, "")
+	if err != nil {
+		t.Fatal("Error getting sync diff: ", err)
+	}
+
+	if len(diff) != 0 {
+		t.Fatal("expected no diff after sync: ", diff)
+	}
+
+	fmt.Println("**** change variable, which is held in the batch")
+	err = client.SendNodePoint(ncD, v.ID, data.Point{Type: data.PointTypeValue,
+		Value: 10}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	diff, err = client.GetSyncDiff(ncD, syncNode.ID, "")
+	if err != nil {
+		t.Fatal("Error getting sync diff: ", err)
+	}
+
+	found := false
+	for _, d := range diff {
+		if d.ID == v.ID {
+			found = true
+			if !d.Local || !d.Remote {
+				t.Error("variable should exist on both sides: ", d)
+			}
+			if len(d.Points) != 1 || d.Points[0] != data.PointTypeValue {
+				t.Error("expected value point to differ: ", d.Points)
+			}
+		}
+	}
+
+	if !found {
+		t.Error("variable not in diff: ", diff)
+	}
+
+	// diff of a subtree only includes that subtree
+	diff, err = client.GetSyncDiff(ncD, syncNode.ID, v.ID)
+	if err != nil {
+		t.Fatal("Error getting sync diff: ", err)
+	}
+
+	if len(diff) != 1 || diff[0].ID != v.ID {
+		t.Error("expected only the variable in diff: ", diff)
+	}
+}
+
+func TestSyncFailover(t *testing.T) {
+	// sync should fail over to a backup upstream when the primary is down and
+	// switch back once the primary is available. Points sent while the
+	// upstream changes must not be lost.
+	t.Setenv("SIOT_DATA", t.TempDir())
+
+	ncB, _, stopB, err := server.TestServer("2")
+
+	if err != nil {
+		t.Fatal("Error starting backup test server: ", err)
+	}
+
+	defer stopB()
+
+	ncD, rootD, stopD, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting downstream test server: ", err)
+	}
+
+	defer stopD()
+
+	primaryOptions := server.TestServerOptions2
+	primaryOptions.StoreFile = "test3.sqlite"
+	primaryOptions.NatsPort = 8920
+	primaryOptions.HTTPPort = "8921"
+	primaryOptions.NatsHTTPPort = 8922
+	primaryOptions.NatsWSPort = 8923
+	primaryOptions.NatsServer = "nats://localhost:8920"
+	primaryOptions.ID = "inst3"
+
+	// collect the values received by either upstream
+	received := make(map[float64]bool)
+	var lock sync.Mutex
+
+	collect := func(nc *nats.Conn) *nats.Subscription {
+		sub, err := nc.Subscribe(client.SubjectNodePoints("varFailover"), func(msg *nats.Msg) {
+			_, points, err := client.DecodeNodePointsMsg(msg)
+			if err != nil {
+				t.Error("Error decoding points: ", err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			for _, p := range points {
+				if p.Type == data.PointTypeValue {
+					received[p.Value] = true
+				}
+			}
+		})
+		if err != nil {
+			t.Fatal("Error subscribing: ", err)
+		}
+		return sub
+	}
+
+	subB := collect(ncB)
+	defer subB.Unsubscribe()
+
+	v := client.Variable{ID: "varFailover", Parent: rootD.ID, Description: "varFailover"}
+	err = client.SendNodeType(ncD, v, "test")
+	if err != nil {
+		t.Fatal("Error sending variable: ", err)
+	}
+
+	syncNode := client.Sync{
+		ID:             "sync-id",
+		Parent:         rootD.ID,
+		Description:    "sync to up",
+		URI:            primaryOptions.NatsServer,
+		BackupURIs:     []string{server.TestServerOptions2.NatsServer},
+		FailoverCount:  1,
+		FailbackPeriod: 1,
+	}
+
+	err = client.SendNodeType(ncD, syncNode, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	// wait for the sync client to start, as points sent before that are
+	// only reconciled by the hash sync
+	start := time.Now()
+	for {
+		nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, syncNode.ID)
+		if err == nil && len(nodes) > 0 && nodes[0].ActiveURI != "" {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("sync client did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// send points during the failover and failback
+	stopSend := make(chan struct{})
+	sendDone := make(chan int)
+	go func() {
+		count := 0
+		for {
+			select {
+			case <-stopSend:
+				sendDone <- count
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			count++
+			err := client.SendNodePoint(ncD, v.ID, data.Point{Type: data.PointTypeValue,
+				Value: float64(count)}, true)
+			if err != nil {
+				t.Error("Error sending point: ", err)
+			}
+		}
+	}()
+
+	// waitSynced waits for the sync client to use uri and the downstream
+	// root node to show up on the upstream
+	waitSynced := func(nc *nats.Conn, uri string, timeout time.Duration) {
+		t.Helper()
+		var s client.Sync
+		start := time.Now()
+		for {
+			if time.Since(start) > timeout {
+				t.Fatalf("not synced to %v, active URI: %v", uri, s.ActiveURI)
+			}
+
+			nodes, err := client.GetNodesType[client.Sync](ncD, rootD.ID, syncNode.ID)
+			if err == nil && len(nodes) > 0 {
+				s = nodes[0]
+			}
+
+			if s.ActiveURI == uri && s.Connected {
+				up, err := client.GetNodes(nc, "all", rootD.ID, "", false)
+				if err == nil && len(up) > 0 {
+					return
+				}
+			}
+
+			time.Sleep(time.Millisecond * 20)
+		}
+	}
+
+	fmt.Println("**** primary is down, so sync to backup")
+	waitSynced(ncB, server.TestServerOptions2.NatsServer, 5*time.Second)
+
+	fmt.Println("**** start primary")
+	// TestServer("2") uses TestServerOptions2, so swap in the primary options
+	backupOptions := server.TestServerOptions2
+	server.TestServerOptions2 = primaryOptions
+	ncP, _, stopP, err := server.TestServer("2")
+	server.TestServerOptions2 = backupOptions
+
+	if err != nil {
+		t.Fatal("Error starting primary test server: ", err)
+	}
+
+	defer stopP()
+
+	subP := collect(ncP)
+	defer subP.Unsubscribe()
+
+	fmt.Println("**** sync switches back to primary")
+	waitSynced(ncP, primaryOptions.NatsServer, 5*time.Second)
+
+	// keep sending on the primary for a bit
+	time.Sleep(200 * time.Millisecond)
+	close(stopSend)
+	count := <-sendDone
+
+	start = time.Now()
+	for {
+		lock.Lock()
+		var missing []float64
+		for i := 1; i <= count; i++ {
+			if !received[float64(i)] {
+				missing = append(missing, float64(i))
+			}
+		}
+		lock.Unlock()
+
+		if len(missing) == 0 {
+			break
+		}
+
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("%v of %v points lost during failover: %v", len(missing), count,
+				missing)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
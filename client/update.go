@@ -84,14 +84,36 @@ func (m *UpdateClient) setError(err error) {
 
 var reUpd = regexp.MustCompile(`(.*)_(\d+\.\d+\.\d+)\.upd`)
 
+// sendEvent records an event on the update node
+func (m *UpdateClient) sendEvent(typ data.EventType, level data.EventLevel, msg string) {
+	err := SendEvent(m.nc, m.config.ID, data.Event{
+		Type:    typ,
+		Level:   level,
+		Message: msg,
+	})
+	if err != nil {
+		m.log.Println("Error sending event: ", err)
+	}
+}
+
 // Run the main logic for this client and blocks until stopped
 func (m *UpdateClient) Run() error {
 	cDownloadFinished := make(chan struct{})
 	// cSetError is used in any goroutines
 	cSetError := make(chan error)
 
-	download := func(v string) error {
+	download := func(v string) (err error) {
+		m.sendEvent(data.EventTypeUpdateDownload, data.EventLevelInfo,
+			"Downloading update: "+v)
+
 		defer func() {
+			if err != nil {
+				m.sendEvent(data.EventTypeUpdateDownloaded, data.EventLevelFault,
+					"Update download failed: "+err.Error())
+			} else {
+				m.sendEvent(data.EventTypeUpdateDownloaded, data.EventLevelInfo,
+					"Update downloaded: "+v)
+			}
 			cDownloadFinished <- struct{}{}
 			_ = SendNodePoint(m.nc, m.config.ID,
 				data.Point{Time: time.Now(), Type: data.PointTypeDownloadOS, Text: ""},
@@ -392,6 +414,7 @@ func (m *UpdateClient) Run() error {
 	}
 
 	reboot := func() {
+		m.sendEvent(data.EventTypeReboot, data.EventLevelInfo, "Rebooting for update")
 		err := exec.Command("reboot").Run()
 		if err != nil {
 			m.log.Println("Error rebooting: ", err)
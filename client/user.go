@@ -1,5 +1,11 @@
 package client
 
+import (
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
 // User represents a user node
 type User struct {
 	ID        string `node:"id"`
@@ -9,4 +15,55 @@ type User struct {
 	Phone     string `point:"phone"`
 	Email     string `point:"email"`
 	Pass      string `point:"pass"`
+	// Notification preferences. Channels are keyed by sms, email, or
+	// webhook. Channels that are not set are enabled. Notifications below
+	// MinSeverity are not delivered. Quiet hours are a daily schedule
+	// (hh:mm) evaluated in Timezone, during which only critical
+	// notifications are delivered.
+	Channels      map[string]bool `point:"channel"`
+	MinSeverity   string          `point:"minSeverity"`
+	QuietStart    string          `point:"quietStart"`
+	QuietEnd      string          `point:"quietEnd"`
+	QuietWeekdays []bool          `point:"quietWeekday"`
+	Timezone      string          `point:"timezone"`
+}
+
+// notificationAllowed returns true if a notification with severity should be
+// delivered to the user over channel at time t.
+func (u User) notificationAllowed(channel, severity string, t time.Time) (bool, error) {
+	if enabled, ok := u.Channels[channel]; ok && !enabled {
+		return false, nil
+	}
+
+	if data.SeverityLevel(severity) < data.SeverityLevel(u.MinSeverity) {
+		return false, nil
+	}
+
+	if severity == data.PointValueCritical {
+		// critical notifications override quiet hours
+		return true, nil
+	}
+
+	quiet, err := u.quietHours(t)
+	if err != nil {
+		return false, err
+	}
+
+	return !quiet, nil
+}
+
+// quietHours returns true if t is in the user's quiet hours
+func (u User) quietHours(t time.Time) (bool, error) {
+	if u.QuietStart == "" || u.QuietEnd == "" {
+		return false, nil
+	}
+
+	loc, err := scheduleLocation(u.Timezone)
+	if err != nil {
+		return false, err
+	}
+
+	s := newSchedule(u.QuietStart, u.QuietEnd, scheduleWeekdays(u.QuietWeekdays), nil, loc)
+
+	return s.activeForTime(t)
 }
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestUserNotificationAllowed(t *testing.T) {
+	user := User{
+		Channels:    map[string]bool{data.PointValueSMS: false, data.PointValueEmail: true},
+		MinSeverity: data.PointValueWarning,
+		QuietStart:  "22:00",
+		QuietEnd:    "6:00",
+		Timezone:    "UTC",
+	}
+
+	day := time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2024, time.January, 10, 23, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		channel  string
+		severity string
+		t        time.Time
+		expected bool
+	}{
+		{data.PointValueEmail, data.PointValueWarning, day, true},
+		{data.PointValueWebhook, data.PointValueWarning, day, true},
+		{data.PointValueSMS, data.PointValueCritical, day, false},
+		{data.PointValueEmail, data.PointValueInfo, day, false},
+		{data.PointValueEmail, "", day, false},
+		{data.PointValueEmail, data.PointValueWarning, night, false},
+		{data.PointValueEmail, data.PointValueCritical, night, true},
+	}
+
+	for _, test := range tests {
+		allowed, err := user.notificationAllowed(test.channel, test.severity, test.t)
+		if err != nil {
+			t.Fatal("notificationAllowed error: ", err)
+		}
+
+		if allowed != test.expected {
+			t.Errorf("expected %v for channel %v, severity %v, time %v", test.expected,
+				test.channel, test.severity, test.t)
+		}
+	}
+
+	// no preferences, everything is delivered
+	allowed, err := User{}.notificationAllowed(data.PointValueSMS, "", night)
+	if err != nil || !allowed {
+		t.Error("notification should be allowed with no preferences: ", err)
+	}
+}
+
+func TestUserQuietHoursWeekdays(t *testing.T) {
+	// 2024-01-13 is a Saturday
+	user := User{
+		QuietStart:    "0:00",
+		QuietEnd:      "23:59",
+		QuietWeekdays: []bool{true, false, false, false, false, false, true},
+		Timezone:      "America/New_York",
+	}
+
+	quiet, err := user.quietHours(time.Date(2024, time.January, 13, 15, 0, 0, 0, time.UTC))
+	if err != nil || !quiet {
+		t.Error("expected quiet hours on Saturday: ", err)
+	}
+
+	// Monday 03:00 UTC is still Sunday in New York
+	quiet, err = user.quietHours(time.Date(2024, time.January, 15, 3, 0, 0, 0, time.UTC))
+	if err != nil || !quiet {
+		t.Error("expected quiet hours on Sunday evening local time: ", err)
+	}
+
+	quiet, err = user.quietHours(time.Date(2024, time.January, 15, 15, 0, 0, 0, time.UTC))
+	if err != nil || quiet {
+		t.Error("expected no quiet hours on Monday: ", err)
+	}
+}
@@ -9,10 +9,17 @@ type EventType int
 
 // define valid events
 const (
-	EventTypeStartSystem EventType = 10
+	EventTypeStartSystem EventType = iota + 10
 	EventTypeStartApp
 	EventTypeSystemUpdate
 	EventTypeAppUpdate
+	EventTypeUpdateDownload
+	EventTypeUpdateDownloaded
+	EventTypeReboot
+	EventTypeSyncConnect
+	EventTypeSyncDisconnect
+	EventTypeRuleActive
+	EventTypeRuleInactive
 )
 
 // EventLevel is used to describe the "severity" of the event and can be used to
@@ -21,7 +28,7 @@ type EventLevel int
 
 // define valid events
 const (
-	EventLevelFault EventLevel = 3
+	EventLevelFault EventLevel = iota + 3
 	EventLevelInfo
 	EventLevelDebug
 )
@@ -29,8 +36,30 @@ const (
 // Event describes something that happened and might be displayed to user in a
 // a sequential log format.
 type Event struct {
-	Time    time.Time
-	Type    EventType
-	Level   EventLevel
-	Message string
+	Time time.Time `json:"time"`
+	// NodeID is the node that generated the event
+	NodeID  string     `json:"nodeId"`
+	Type    EventType  `json:"type"`
+	Level   EventLevel `json:"level"`
+	Message string     `json:"message"`
+}
+
+// EventQuery is used to query events for a node and all nodes below it.
+// Events are returned newest first.
+type EventQuery struct {
+	// Level returns events at this level or more severe. If 0, events
+	// of all levels are returned.
+	Level EventLevel `json:"level"`
+	// Types limits the events returned to these types
+	Types []EventType `json:"types"`
+	Start time.Time   `json:"start"`
+	Stop  time.Time   `json:"stop"`
+	// Limit is the maximum number of events returned. If 0, 1000 is used.
+	Limit int `json:"limit"`
+}
+
+// EventResults is the result of an event query
+type EventResults struct {
+	ErrorMessage string  `json:"error,omitempty"`
+	Events       []Event `json:"events"`
 }
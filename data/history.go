@@ -28,6 +28,37 @@ type HistoryQuery struct {
 	AggregateWindow *time.Duration `json:"aggregateWindow"`
 }
 
+// NodeHistoryQuery is used to request the history of a node point from the
+// configured history backend (Influx, local store history, etc).
+type NodeHistoryQuery struct {
+	// Type and Key of the point. If Key is blank, all keys are returned.
+	Type            string         `json:"type"`
+	Key             string         `json:"key"`
+	Start           time.Time      `json:"start"`
+	Stop            time.Time      `json:"stop"`
+	AggregateWindow *time.Duration `json:"aggregateWindow"`
+}
+
+// HistoryQuery converts the request into a HistoryQuery for a node
+func (q NodeHistoryQuery) HistoryQuery(nodeID string) HistoryQuery {
+	tags := TagFilters{"node.id": nodeID}
+
+	if q.Type != "" {
+		tags["type"] = q.Type
+	}
+
+	if q.Key != "" {
+		tags["key"] = q.Key
+	}
+
+	return HistoryQuery{
+		Start:           q.Start,
+		Stop:            q.Stop,
+		TagFilters:      tags,
+		AggregateWindow: q.AggregateWindow,
+	}
+}
+
 // Flux generates a Flux query for the HistoryQuery. Returns an error if tag
 // filters could not be sanitized.
 func (qry HistoryQuery) Flux(bucket, measurement string) (string, error) {
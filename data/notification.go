@@ -12,6 +12,21 @@ type Notification struct {
 	SourceNode string `json:"sourceNode"`
 	Subject    string `json:"subject"`
 	Message    string `json:"message"`
+	// Severity: info, warning, critical
+	Severity string `json:"severity"`
+}
+
+// SeverityLevel returns a number for a severity so severities can be
+// compared. Blank or unknown severities are treated as info.
+func SeverityLevel(severity string) int {
+	switch severity {
+	case PointValueWarning:
+		return 1
+	case PointValueCritical:
+		return 2
+	default:
+		return 0
+	}
 }
 
 // ToPb converts to protobuf data
@@ -22,6 +37,7 @@ func (n *Notification) ToPb() ([]byte, error) {
 		SourceNode: n.SourceNode,
 		Subject:    n.Subject,
 		Msg:        n.Message,
+		Severity:   n.Severity,
 	}
 
 	return proto.Marshal(&pbNot)
@@ -42,5 +58,6 @@ func PbDecodeNotification(data []byte) (Notification, error) {
 		SourceNode: pbNot.SourceNode,
 		Subject:    pbNot.Subject,
 		Message:    pbNot.Msg,
+		Severity:   pbNot.Severity,
 	}, nil
 }
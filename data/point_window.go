@@ -0,0 +1,156 @@
+package data
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// PointWindowMaxLen is the maximum number of points kept in a PointWindow.
+// When the window is full, the oldest points are dropped.
+const PointWindowMaxLen = 10000
+
+// PointWindow keeps a sliding time window of points and can be used to
+// calculate statistics (min, max, mean, delta, slope) over the window.
+type PointWindow struct {
+	windowLen time.Duration
+	points    []Point
+}
+
+// NewPointWindow initializes and returns a sliding window
+func NewPointWindow(windowLen time.Duration) *PointWindow {
+	return &PointWindow{
+		windowLen: windowLen,
+	}
+}
+
+// SetWindowLen changes the length of the window
+func (pw *PointWindow) SetWindowLen(windowLen time.Duration) {
+	pw.windowLen = windowLen
+}
+
+// Add adds a point to the window and removes points that are older
+// than the window length relative to the newest point. If the window
+// has more than PointWindowMaxLen points, the oldest are removed.
+func (pw *PointWindow) Add(p Point) {
+	pw.points = append(pw.points, p)
+
+	// points normally arrive in order, so only sort when needed
+	if l := len(pw.points); l > 1 && p.Time.Before(pw.points[l-2].Time) {
+		sort.SliceStable(pw.points, func(i, j int) bool {
+			return pw.points[i].Time.Before(pw.points[j].Time)
+		})
+	}
+
+	pw.Prune(pw.points[len(pw.points)-1].Time)
+
+	if l := len(pw.points); l > PointWindowMaxLen {
+		pw.points = pw.points[l-PointWindowMaxLen:]
+	}
+}
+
+// Prune removes points older than the window length relative to t
+func (pw *PointWindow) Prune(t time.Time) {
+	start := t.Add(-pw.windowLen)
+
+	i := 0
+	for i < len(pw.points) && pw.points[i].Time.Before(start) {
+		i++
+	}
+
+	pw.points = pw.points[i:]
+}
+
+// Expires returns the time the oldest point leaves the window. Returns
+// false if the window is empty.
+func (pw *PointWindow) Expires() (time.Time, bool) {
+	if len(pw.points) == 0 {
+		return time.Time{}, false
+	}
+
+	return pw.points[0].Time.Add(pw.windowLen), true
+}
+
+// Len returns the number of points in the window
+func (pw *PointWindow) Len() int {
+	return len(pw.points)
+}
+
+// Min returns the minimum value in the window
+func (pw *PointWindow) Min() float64 {
+	if len(pw.points) == 0 {
+		return 0
+	}
+
+	ret := math.Inf(1)
+	for _, p := range pw.points {
+		ret = math.Min(ret, p.Value)
+	}
+
+	return ret
+}
+
+// Max returns the maximum value in the window
+func (pw *PointWindow) Max() float64 {
+	if len(pw.points) == 0 {
+		return 0
+	}
+
+	ret := math.Inf(-1)
+	for _, p := range pw.points {
+		ret = math.Max(ret, p.Value)
+	}
+
+	return ret
+}
+
+// Mean returns the average value in the window
+func (pw *PointWindow) Mean() float64 {
+	if len(pw.points) == 0 {
+		return 0
+	}
+
+	var total float64
+	for _, p := range pw.points {
+		total += p.Value
+	}
+
+	return total / float64(len(pw.points))
+}
+
+// Delta returns the difference between the newest and oldest point
+// in the window
+func (pw *PointWindow) Delta() float64 {
+	if len(pw.points) < 2 {
+		return 0
+	}
+
+	return pw.points[len(pw.points)-1].Value - pw.points[0].Value
+}
+
+// Slope returns the rate of change in units per minute calculated using
+// a least squares fit of the points in the window
+func (pw *PointWindow) Slope() float64 {
+	if len(pw.points) < 2 {
+		return 0
+	}
+
+	t0 := pw.points[0].Time
+	n := float64(len(pw.points))
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range pw.points {
+		x := p.Time.Sub(t0).Minutes()
+		sumX += x
+		sumY += p.Value
+		sumXY += x * p.Value
+		sumXX += x * x
+	}
+
+	d := n*sumXX - sumX*sumX
+	if d == 0 {
+		return 0
+	}
+
+	return (n*sumXY - sumX*sumY) / d
+}
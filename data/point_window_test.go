@@ -0,0 +1,79 @@
+package data
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPointWindow(t *testing.T) {
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	pw := NewPointWindow(10 * time.Minute)
+
+	// add a point every minute that increases by 2 every minute
+	for i := 0; i <= 15; i++ {
+		pw.Add(Point{Time: start.Add(time.Duration(i) * time.Minute), Value: float64(i * 2)})
+	}
+
+	// points 5..15 are in the window
+	if pw.Len() != 11 {
+		t.Fatal("expected 11 points in window, got: ", pw.Len())
+	}
+
+	tests := []struct {
+		name     string
+		value    float64
+		expected float64
+	}{
+		{"min", pw.Min(), 10},
+		{"max", pw.Max(), 30},
+		{"mean", pw.Mean(), 20},
+		{"delta", pw.Delta(), 20},
+		{"slope", pw.Slope(), 2},
+	}
+
+	for _, test := range tests {
+		if math.Abs(test.value-test.expected) > 1e-9 {
+			t.Errorf("%v: expected %v, got %v", test.name, test.expected, test.value)
+		}
+	}
+
+	// out of order point should be sorted into the window
+	pw.Add(Point{Time: start.Add(5*time.Minute + 30*time.Second), Value: 100})
+	if pw.Max() != 100 || pw.Delta() != 20 {
+		t.Error("out of order point not handled correctly")
+	}
+
+	pw.Prune(start.Add(30 * time.Minute))
+	if pw.Len() != 0 {
+		t.Error("expected empty window after prune, got: ", pw.Len())
+	}
+}
+
+func TestPointWindowLimits(t *testing.T) {
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	pw := NewPointWindow(time.Hour)
+
+	if _, ok := pw.Expires(); ok {
+		t.Error("empty window should not expire")
+	}
+
+	for i := 0; i < PointWindowMaxLen+10; i++ {
+		pw.Add(Point{Time: start.Add(time.Duration(i) * time.Millisecond), Value: float64(i)})
+	}
+
+	if pw.Len() != PointWindowMaxLen {
+		t.Fatal("expected window to be limited, got: ", pw.Len())
+	}
+
+	if pw.Min() != 10 {
+		t.Error("expected oldest points to be dropped, min: ", pw.Min())
+	}
+
+	expires, ok := pw.Expires()
+	if !ok || !expires.Equal(start.Add(time.Hour+10*time.Millisecond)) {
+		t.Error("wrong expire time: ", expires)
+	}
+}
@@ -127,6 +127,15 @@ const (
 	PointTypeBucket = "bucket"
 	PointTypeOrg    = "org"
 
+	// clients that buffer data in a persistent queue while the destination
+	// is unreachable report the queue depth and the number of dropped
+	// records. queueSize is the maximum number of queued records.
+	PointTypeQueueSize    = "queueSize"
+	PointTypeQueueDepth   = "queueDepth"
+	PointTypeQueueDropped = "queueDropped"
+	// queueAge is the max age of queued records in seconds
+	PointTypeQueueAge = "queueAge"
+
 	// a rule node describes a rule that may run on the system
 	NodeTypeRule = "rule"
 
@@ -137,15 +146,21 @@ const (
 	PointTypeConditionType = "conditionType"
 	PointValuePointValue   = "pointValue"
 	PointValueSchedule     = "schedule"
+	PointValueStale        = "stale"
+	PointValuePointWindow  = "pointWindow"
 
 	PointTypeNodeID = "nodeID"
 
 	PointTypeTrigger = "trigger"
 
-	PointTypeStart   = "start"
-	PointTypeEnd     = "end"
-	PointTypeWeekday = "weekday"
-	PointTypeDate    = "date"
+	PointTypeStart    = "start"
+	PointTypeEnd      = "end"
+	PointTypeWeekday  = "weekday"
+	PointTypeDate     = "date"
+	PointTypeTimezone = "timezone"
+	// PointValueLocal is a timezone value for the time zone the
+	// instance is configured for
+	PointValueLocal = "local"
 
 	PointTypePointID    = "pointID"
 	PointTypePointKey   = "pointKey"
@@ -156,18 +171,46 @@ const (
 	PointValueOnOff     = "onOff"
 	PointValueText      = "text"
 
-	PointTypeOperator     = "operator"
-	PointValueGreaterThan = ">"
-	PointValueLessThan    = "<"
-	PointValueEqual       = "="
-	PointValueNotEqual    = "!="
-	PointValueOn          = "on"
-	PointValueOff         = "off"
-	PointValueContains    = "contains"
-
-	PointTypeValueText = "valueText"
-
-	PointTypeMinActive = "minActive"
+	PointTypeOperator            = "operator"
+	PointValueGreaterThan        = ">"
+	PointValueGreaterThanOrEqual = ">="
+	PointValueLessThan           = "<"
+	PointValueLessThanOrEqual    = "<="
+	PointValueEqual              = "="
+	PointValueNotEqual           = "!="
+	PointValueBetween            = "between"
+	PointValueOutside            = "outside"
+	PointValueOn                 = "on"
+	PointValueOff                = "off"
+	PointValueContains           = "contains"
+	PointValueStartsWith         = "startsWith"
+	PointValueEndsWith           = "endsWith"
+	PointValueRegex              = "regex"
+
+	PointTypeValueText  = "valueText"
+	PointTypeValue2     = "value2"
+	PointTypeHysteresis = "hysteresis"
+
+	PointTypeMinActive   = "minActive"
+	PointTypeMinInactive = "minInactive"
+
+	PointTypeTimeout = "timeout"
+
+	PointTypeWindow         = "window"
+	PointTypeWindowFunction = "windowFunction"
+	PointValueDelta         = "delta"
+	PointValueSlope         = "slope"
+	PointValueMin           = "min"
+	PointValueMax           = "max"
+	PointValueMean          = "mean"
+
+	PointTypeInvert = "invert"
+
+	NodeTypeConditionGroup = "conditionGroup"
+
+	PointTypeConditionOperator = "conditionOperator"
+	PointValueAnd              = "and"
+	PointValueOr               = "or"
 
 	NodeTypeAction         = "action"
 	NodeTypeActionInactive = "actionInactive"
@@ -177,6 +220,28 @@ const (
 	PointValueNotify    = "notify"
 	PointValueSetValue  = "setValue"
 	PointValuePlayAudio = "playAudio"
+	PointValueHTTP      = "http"
+	PointValueExec      = "exec"
+	PointValueAlarm     = "alarm"
+
+	PointTypeMethod  = "method"
+	PointTypeHeader  = "header"
+	PointTypeBody    = "body"
+	PointTypeRetries = "retries"
+
+	PointTypeCommand  = "command"
+	PointTypeArg      = "arg"
+	PointTypeExitCode = "exitCode"
+	PointTypeStdout   = "stdout"
+	PointTypeStderr   = "stderr"
+
+	PointTypeDelay  = "delay"
+	PointTypeRepeat = "repeat"
+
+	PointTypeEscalation      = "escalation"
+	PointTypeEscalationLevel = "escalationLevel"
+	PointTypeOpen            = "open"
+	PointTypeAck             = "ack"
 
 	// Transient points that are used for notifications, etc.
 	// These points are not stored in the state of any node,
@@ -195,11 +260,81 @@ const (
 	PointTypeAuthToken = "authToken"
 	PointTypeFrom      = "from"
 
+	PointTypeUsername = "username"
+	PointTypePassword = "password"
+	PointTypeSecurity = "security"
+
+	PointValueNone     = "none"
+	PointValueStartTLS = "starttls"
+	PointValueTLS      = "tls"
+
+	PointValueWebhook = "webhook"
+
+	PointTypeSeverity = "severity"
+
+	PointValueInfo     = "info"
+	PointValueWarning  = "warning"
+	PointValueCritical = "critical"
+
+	// user notification preferences
+	PointValueSMS         = "sms"
+	PointValueEmail       = "email"
+	PointTypeMinSeverity  = "minSeverity"
+	PointTypeQuietStart   = "quietStart"
+	PointTypeQuietEnd     = "quietEnd"
+	PointTypeQuietWeekday = "quietWeekday"
+
+	// an alarm node tracks an alarm condition through the ISA-18.2
+	// alarm states
+	NodeTypeAlarm = "alarm"
+
+	PointTypeState        = "state"
+	PointTypeMessage      = "message"
+	PointTypeText         = "text"
+	PointTypeShelve       = "shelve"
+	PointTypeShelvedUntil = "shelvedUntil"
+	PointTypeJournal      = "journal"
+	PointTypeJournalCount = "journalCount"
+
+	PointValueNormal       = "normal"
+	PointValueUnackActive  = "unackActive"
+	PointValueAckActive    = "ackActive"
+	PointValueUnackCleared = "unackCleared"
+
 	NodeTypeVariable      = "variable"
 	PointTypeVariableType = "variableType"
 
 	NodeTypeSync = "sync"
 
+	// sync filters select the nodes and points that are synced upstream
+	PointTypeIncludeNodeType  = "includeNodeType"
+	PointTypeExcludeNodeType  = "excludeNodeType"
+	PointTypeIncludeNode      = "includeNode"
+	PointTypeExcludeNode      = "excludeNode"
+	PointTypeIncludePointType = "includePointType"
+	PointTypeExcludePointType = "excludePointType"
+
+	// sync batching collects points for batchWindow (ms) before sending
+	// them upstream, and optionally compresses the batch
+	PointTypeBatchWindow = "batchWindow"
+	PointTypeCompress    = "compress"
+	PointTypeBytesSent   = "bytesSent"
+	PointTypeBytesSaved  = "bytesSaved"
+
+	// sync status points. lastSync is the time (RFC3339) of the last
+	// successful sync, latency is the round trip time to the upstream (ms),
+	// and outOfSync is the number of nodes that were synced in the last pass.
+	PointTypeLastSync      = "lastSync"
+	PointTypeLatency       = "latency"
+	PointTypeOutOfSync     = "outOfSync"
+	PointTypeBytesReceived = "bytesReceived"
+
+	// sync failover to backup upstream URIs
+	PointTypeBackupURI      = "backupUri"
+	PointTypeFailoverCount  = "failoverCount"
+	PointTypeFailbackPeriod = "failbackPeriod"
+	PointTypeActiveURI      = "activeUri"
+
 	PointTypeMetricNatsCycleNodePoint          = "metricNatsCycleNodePoint"
 	PointTypeMetricNatsCycleNodeEdgePoint      = "metricNatsCycleNodeEdgePoint"
 	PointTypeMetricNatsCycleNode               = "metricNatsCycleNode"
@@ -0,0 +1,31 @@
+package data
+
+// SyncDiffQuery is used to request the nodes that differ between a sync
+// client's local instance and the upstream.
+type SyncDiffQuery struct {
+	// NodeID is the root of the subtree to compare. If empty, the root
+	// node of the instance is used.
+	NodeID string `json:"nodeId"`
+}
+
+// SyncDiffNode describes a node whose hash differs between the local
+// instance and the upstream
+type SyncDiffNode struct {
+	ID          string `json:"id"`
+	Parent      string `json:"parent"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	// Local and Remote are false if the node does not exist on that side
+	Local      bool   `json:"local"`
+	Remote     bool   `json:"remote"`
+	LocalHash  uint32 `json:"localHash"`
+	RemoteHash uint32 `json:"remoteHash"`
+	// Points lists the node and edge point types (type:key) that differ
+	Points []string `json:"points,omitempty"`
+}
+
+// SyncDiffResults is the result of a sync diff request
+type SyncDiffResults struct {
+	ErrorMessage string         `json:"error,omitempty"`
+	Nodes        []SyncDiffNode `json:"nodes"`
+}
@@ -0,0 +1,169 @@
+// Package smtptest provides a minimal in-process SMTP server for tests.
+package smtptest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Message is an email received by the test server
+type Message struct {
+	From string
+	To   []string
+	Data string
+	// User is set if the client authenticated
+	User string
+	// TLS is set if the message was sent over a TLS connection
+	TLS bool
+}
+
+// Server is a SMTP server that accepts all mail and sends received messages
+// to the Messages channel.
+type Server struct {
+	Messages chan Message
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+	wg        sync.WaitGroup
+}
+
+// NewServer starts a SMTP server on a random localhost port. If tlsConfig is
+// set, STARTTLS is supported.
+func NewServer(tlsConfig *tls.Config) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Messages:  make(chan Message, 10),
+		listener:  l,
+		tlsConfig: tlsConfig,
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.handle(conn)
+			}()
+		}
+	}()
+
+	return s, nil
+}
+
+// Addr returns the host and port the server is listening on
+func (s *Server) Addr() (string, int) {
+	a := s.listener.Addr().(*net.TCPAddr)
+	return a.IP.String(), a.Port
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var msg Message
+
+	reply("220 smtptest ready")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-smtptest")
+			if s.tlsConfig != nil && !msg.TLS {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case cmd == "STARTTLS":
+			if s.tlsConfig == nil {
+				reply("502 not supported")
+				continue
+			}
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			err := tlsConn.Handshake()
+			if err != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(conn)
+			msg.TLS = true
+		case strings.HasPrefix(cmd, "AUTH PLAIN"):
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				reply("501 missing credentials")
+				continue
+			}
+			d, err := base64.StdEncoding.DecodeString(fields[2])
+			if err != nil {
+				reply("501 invalid credentials")
+				continue
+			}
+			creds := strings.Split(string(d), "\x00")
+			if len(creds) == 3 {
+				msg.User = creds[1]
+			}
+			reply("235 authenticated")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.From = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.To = append(msg.To, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.Data = data.String()
+			s.Messages <- msg
+			msg = Message{TLS: msg.TLS, User: msg.User}
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package msg
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// smtpTimeout is the maximum time to connect to a SMTP server
+const smtpTimeout = 30 * time.Second
+
+// SMTP can be used to send email through a SMTP server
+type SMTP struct {
+	host      string
+	port      int
+	security  string
+	user      string
+	pass      string
+	from      string
+	tlsConfig *tls.Config
+}
+
+// NewSMTP creates a new SMTP messenger. Security is one of none, starttls, or
+// tls, and defaults to starttls. If port is 0, the default port for the
+// security mode is used.
+func NewSMTP(host string, port int, security, user, pass, from string) *SMTP {
+	if security == "" {
+		security = data.PointValueStartTLS
+	}
+
+	if port == 0 {
+		switch security {
+		case data.PointValueTLS:
+			port = 465
+		case data.PointValueNone:
+			port = 25
+		default:
+			port = 587
+		}
+	}
+
+	return &SMTP{
+		host:      host,
+		port:      port,
+		security:  security,
+		user:      user,
+		pass:      pass,
+		from:      from,
+		tlsConfig: &tls.Config{ServerName: host},
+	}
+}
+
+// SetTLSConfig sets the TLS config used for TLS and STARTTLS connections.
+// This can be used to set custom root CAs.
+func (m *SMTP) SetTLSConfig(c *tls.Config) {
+	m.tlsConfig = c
+}
+
+// SendEmail sends a plain text email
+func (m *SMTP) SendEmail(to, subject, body string) error {
+	if m.host == "" {
+		return errors.New("SMTP host not set")
+	}
+
+	if m.from == "" {
+		return errors.New("SMTP from address not set")
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+
+	switch m.security {
+	case data.PointValueTLS:
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, m.tlsConfig)
+	case data.PointValueNone, data.PointValueStartTLS:
+		conn, err = dialer.Dial("tcp", addr)
+	default:
+		return fmt.Errorf("Invalid SMTP security: %v", m.security)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Error connecting to SMTP server: %w", err)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP error: %w", err)
+	}
+	defer c.Close()
+
+	if m.security == data.PointValueStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+
+		err = c.StartTLS(m.tlsConfig)
+		if err != nil {
+			return fmt.Errorf("SMTP STARTTLS error: %w", err)
+		}
+	}
+
+	if m.user != "" {
+		err = c.Auth(smtp.PlainAuth("", m.user, m.pass, m.host))
+		if err != nil {
+			return fmt.Errorf("SMTP auth error: %w", err)
+		}
+	}
+
+	err = c.Mail(m.from)
+	if err != nil {
+		return fmt.Errorf("SMTP from error: %w", err)
+	}
+
+	err = c.Rcpt(to)
+	if err != nil {
+		return fmt.Errorf("SMTP recipient error: %w", err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP data error: %w", err)
+	}
+
+	_, err = w.Write(emailMessage(m.from, to, subject, body))
+	if err != nil {
+		return fmt.Errorf("SMTP write error: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("SMTP data error: %w", err)
+	}
+
+	return c.Quit()
+}
+
+func emailMessage(from, to, subject, body string) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %v\r\n", from)
+	fmt.Fprintf(&b, "To: %v\r\n", to)
+	fmt.Fprintf(&b, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
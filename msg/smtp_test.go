@@ -0,0 +1,87 @@
+package msg
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/internal/smtptest"
+)
+
+func TestSMTPSendEmail(t *testing.T) {
+	// use the httptest certificate for the SMTP server
+	tlsSrv := httptest.NewTLSServer(nil)
+	defer tlsSrv.Close()
+
+	srv, err := smtptest.NewServer(&tls.Config{Certificates: tlsSrv.TLS.Certificates})
+	if err != nil {
+		t.Fatal("Error starting SMTP server: ", err)
+	}
+	defer srv.Close()
+
+	host, port := srv.Addr()
+	rootCAs := tlsSrv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	tests := []struct {
+		security string
+		user     string
+		tls      bool
+	}{
+		{data.PointValueNone, "", false},
+		{data.PointValueStartTLS, "joe", true},
+	}
+
+	for _, test := range tests {
+		m := NewSMTP(host, port, test.security, test.user, "secret", "siot@example.com")
+		m.SetTLSConfig(&tls.Config{RootCAs: rootCAs, ServerName: "example.com"})
+
+		err := m.SendEmail("joe@example.com", "alert", "motor overload\nline 1")
+		if err != nil {
+			t.Fatalf("%v: error sending email: %v", test.security, err)
+		}
+
+		select {
+		case msg := <-srv.Messages:
+			if msg.From != "siot@example.com" || len(msg.To) != 1 ||
+				msg.To[0] != "joe@example.com" {
+				t.Errorf("%v: wrong addresses: %+v", test.security, msg)
+			}
+
+			if !strings.Contains(msg.Data, "Subject: alert\r\n") ||
+				!strings.Contains(msg.Data, "\r\n\r\nmotor overload\r\nline 1\r\n") {
+				t.Errorf("%v: wrong data: %v", test.security, msg.Data)
+			}
+
+			if msg.TLS != test.tls {
+				t.Errorf("%v: expected TLS: %v", test.security, test.tls)
+			}
+
+			if msg.User != test.user {
+				t.Errorf("%v: expected user: %v, got: %v", test.security, test.user,
+					msg.User)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: timeout waiting for message", test.security)
+		}
+	}
+}
+
+func TestSMTPStartTLSNotSupported(t *testing.T) {
+	srv, err := smtptest.NewServer(nil)
+	if err != nil {
+		t.Fatal("Error starting SMTP server: ", err)
+	}
+	defer srv.Close()
+
+	host, port := srv.Addr()
+
+	m := NewSMTP(host, port, data.PointValueStartTLS, "", "", "siot@example.com")
+	err = m.SendEmail("joe@example.com", "alert", "motor overload")
+	if err == nil {
+		t.Fatal("expected error when STARTTLS is not supported")
+	}
+}
@@ -37,3 +37,8 @@ func (m *Twilio) SendSMS(to, msg string) error {
 
 	return nil
 }
+
+// SetBaseURL overrides the Twilio API URL. This is typically used for testing.
+func (m *Twilio) SetBaseURL(url string) {
+	m.twilioClient.Base = url
+}
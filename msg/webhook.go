@@ -0,0 +1,48 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Webhook can be used to send messages as JSON to a HTTP endpoint
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a new webhook messenger
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Send POSTs v encoded as JSON to the webhook URL
+func (w *Webhook) Send(v any) error {
+	if w.url == "" {
+		return errors.New("webhook URL not set")
+	}
+
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status: %v", resp.Status)
+	}
+
+	return nil
+}
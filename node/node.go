@@ -54,8 +54,15 @@ func (m *Manager) init() error {
 
 	m.rootNodeID = rootNode.ID
 
+	m.sendEvent(data.EventTypeStartApp, "Simple IoT started, version: "+m.appVersion)
+
 	appVer, ok := rootNode.Points.Find(data.PointTypeVersionApp, "")
 	if !ok || appVer.Text != m.appVersion {
+		if ok {
+			m.sendEvent(data.EventTypeAppUpdate,
+				fmt.Sprintf("App updated from %v to %v", appVer.Text, m.appVersion))
+		}
+
 		log.Println("Setting app version:", m.appVersion)
 		err := client.SendNodePoint(m.nc, rootNode.ID, data.Point{
 			Type: data.PointTypeVersionApp,
@@ -75,6 +82,11 @@ func (m *Manager) init() error {
 		log.Println("OS version:", osVer)
 		osVerStored, ok := rootNode.Points.Find(data.PointTypeVersionOS, "")
 		if !ok || osVer.String() != osVerStored.Text {
+			if ok {
+				m.sendEvent(data.EventTypeSystemUpdate,
+					fmt.Sprintf("OS updated from %v to %v", osVerStored.Text, osVer))
+			}
+
 			log.Println("Setting os version:", osVer)
 			err := client.SendNodePoint(m.nc, rootNode.ID, data.Point{
 				Type: data.PointTypeVersionOS,
@@ -94,6 +106,17 @@ func (m *Manager) init() error {
 	return nil
 }
 
+func (m *Manager) sendEvent(typ data.EventType, msg string) {
+	err := client.SendEvent(m.nc, m.rootNodeID, data.Event{
+		Type:    typ,
+		Level:   data.EventLevelInfo,
+		Message: msg,
+	})
+	if err != nil {
+		log.Println("Error sending event:", err)
+	}
+}
+
 // Start manager
 func (m *Manager) Start() error {
 	if err := m.init(); err != nil {
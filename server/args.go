@@ -6,6 +6,8 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/simpleiot/simpleiot/assets/files"
 	"github.com/simpleiot/simpleiot/system"
@@ -29,6 +31,12 @@ func Args(args []string, flags *flag.FlagSet) (Options, error) {
 	flagNatsDisableServer := flags.Bool("natsDisableServer", false, "disable NATS server (if you want to run NATS separately)")
 	flagStore := flags.String("store", "siot.sqlite", "store file, default siot.sqlite")
 	flagResetStore := flags.Bool("resetStore", false, "permanently wipe data in store at start-up")
+	flagEventRetention := flags.Duration("eventRetention", 30*24*time.Hour, "how long events are kept in the store")
+	flagHistory := flags.Bool("history", false, "record history of all points in the store")
+	flagHistoryNodeTypes := flags.String("historyNodeTypes", "", "comma separated node types to record history for")
+	flagHistoryRetention := flags.Duration("historyRetention", 90*24*time.Hour, "how long history is kept in the store")
+	flagHistoryRaw := flags.Duration("historyRaw", 7*24*time.Hour, "how long numeric history is kept at full resolution before downsampling (text points are kept until historyRetention)")
+	flagHistoryRollup := flags.Duration("historyRollup", time.Hour, "window history is downsampled into")
 	flagAuthToken := flags.String("token", "", "auth token")
 	flagSyslog := flags.Bool("syslog", false, "log to syslog instead of stdout")
 	flagDev := flags.Bool("dev", false, "run server in development mode")
@@ -135,6 +143,13 @@ func Args(args []string, flags *flag.FlagSet) (Options, error) {
 		}
 	}
 
+	var historyNodeTypes []string
+	if *flagHistoryNodeTypes != "" {
+		for _, t := range strings.Split(*flagHistoryNodeTypes, ",") {
+			historyNodeTypes = append(historyNodeTypes, strings.TrimSpace(t))
+		}
+	}
+
 	// finally, start web server
 	port := os.Getenv("SIOT_HTTP_PORT")
 	if port == "" {
@@ -152,25 +167,31 @@ func Args(args []string, flags *flag.FlagSet) (Options, error) {
 
 	// TODO, convert this to builder pattern
 	o := Options{
-		StoreFile:         storeFilePath,
-		ResetStore:        *flagResetStore,
-		HTTPPort:          port,
-		DebugHTTP:         *flagDebugHTTP,
-		DebugLifecycle:    *flagDebugLifecycle,
-		NatsServer:        natsServer,
-		NatsDisableServer: *flagNatsDisableServer,
-		NatsPort:          natsPort,
-		NatsHTTPPort:      natsHTTPPort,
-		NatsWSPort:        natsWSPort,
-		NatsTLSCert:       natsTLSCert,
-		NatsTLSKey:        natsTLSKey,
-		NatsTLSTimeout:    natsTLSTimeout,
-		AuthToken:         authToken,
-		ParticleAPIKey:    particleAPIKey,
-		OSVersionField:    osVersionField,
-		Dev:               *flagDev,
-		CustomUIDir:       *flagCustomUIDir,
-		UIAssetsDebug:     *flagUIAssetsDebug,
+		StoreFile:           storeFilePath,
+		ResetStore:          *flagResetStore,
+		EventRetention:      *flagEventRetention,
+		History:             *flagHistory,
+		HistoryNodeTypes:    historyNodeTypes,
+		HistoryRetention:    *flagHistoryRetention,
+		HistoryRawRetention: *flagHistoryRaw,
+		HistoryRollup:       *flagHistoryRollup,
+		HTTPPort:            port,
+		DebugHTTP:           *flagDebugHTTP,
+		DebugLifecycle:      *flagDebugLifecycle,
+		NatsServer:          natsServer,
+		NatsDisableServer:   *flagNatsDisableServer,
+		NatsPort:            natsPort,
+		NatsHTTPPort:        natsHTTPPort,
+		NatsWSPort:          natsWSPort,
+		NatsTLSCert:         natsTLSCert,
+		NatsTLSKey:          natsTLSKey,
+		NatsTLSTimeout:      natsTLSTimeout,
+		AuthToken:           authToken,
+		ParticleAPIKey:      particleAPIKey,
+		OSVersionField:      osVersionField,
+		Dev:                 *flagDev,
+		CustomUIDir:         *flagCustomUIDir,
+		UIAssetsDebug:       *flagUIAssetsDebug,
 	}
 
 	return o, nil
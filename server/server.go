@@ -28,29 +28,35 @@ var ErrServerStopped = errors.New("Server stopped")
 
 // Options used for starting Simple IoT
 type Options struct {
-	StoreFile         string
-	ResetStore        bool
-	DataDir           string
-	HTTPPort          string
-	DebugHTTP         bool
-	DebugLifecycle    bool
-	NatsServer        string
-	NatsDisableServer bool
-	NatsPort          int
-	NatsHTTPPort      int
-	NatsWSPort        int
-	NatsTLSCert       string
-	NatsTLSKey        string
-	NatsTLSTimeout    float64
-	AuthToken         string
-	ParticleAPIKey    string
-	AppVersion        string
-	OSVersionField    string
-	LogNats           bool
-	Dev               bool
-	CustomUIDir       string
-	CustomUIFS        fs.FS
-	UIAssetsDebug     bool
+	StoreFile           string
+	ResetStore          bool
+	EventRetention      time.Duration
+	History             bool
+	HistoryNodeTypes    []string
+	HistoryRetention    time.Duration
+	HistoryRawRetention time.Duration
+	HistoryRollup       time.Duration
+	DataDir             string
+	HTTPPort            string
+	DebugHTTP           bool
+	DebugLifecycle      bool
+	NatsServer          string
+	NatsDisableServer   bool
+	NatsPort            int
+	NatsHTTPPort        int
+	NatsWSPort          int
+	NatsTLSCert         string
+	NatsTLSKey          string
+	NatsTLSTimeout      float64
+	AuthToken           string
+	ParticleAPIKey      string
+	AppVersion          string
+	OSVersionField      string
+	LogNats             bool
+	Dev                 bool
+	CustomUIDir         string
+	CustomUIFS          fs.FS
+	UIAssetsDebug       bool
 	// optional ID (must be unique) for this instance, otherwise, a UUID will be used
 	ID string
 }
@@ -183,11 +189,17 @@ func (s *Server) Run() error {
 	// ====================================
 
 	storeParams := store.Params{
-		File:      o.StoreFile,
-		AuthToken: o.AuthToken,
-		Server:    o.NatsServer,
-		Nc:        s.nc,
-		ID:        s.options.ID,
+		File:                o.StoreFile,
+		AuthToken:           o.AuthToken,
+		Server:              o.NatsServer,
+		Nc:                  s.nc,
+		ID:                  s.options.ID,
+		EventRetention:      o.EventRetention,
+		History:             o.History,
+		HistoryNodeTypes:    o.HistoryNodeTypes,
+		HistoryRetention:    o.HistoryRetention,
+		HistoryRawRetention: o.HistoryRawRetention,
+		HistoryRollup:       o.HistoryRollup,
 	}
 
 	siotStore, err := store.NewStore(storeParams)
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// eventQueryLimit is the default maximum number of events returned by a query
+const eventQueryLimit = 1000
+
+// defaultEventRetention is how long events are kept if not configured
+const defaultEventRetention = 30 * 24 * time.Hour
+
+func (sdb *DbSqlite) insertEvent(e data.Event) error {
+	sdb.writeLock.Lock()
+	defer sdb.writeLock.Unlock()
+
+	_, err := sdb.db.Exec(`INSERT INTO events(time, node_id, type, level, message)
+		VALUES(?, ?, ?, ?, ?)`,
+		e.Time.UnixNano(), e.NodeID, e.Type, e.Level, e.Message)
+
+	return err
+}
+
+// queryEvents returns events that match the query. If nodeIDs is not nil,
+// only events for these nodes are returned.
+func (sdb *DbSqlite) queryEvents(nodeIDs []string, q data.EventQuery) ([]data.Event, error) {
+	var where []string
+	var args []any
+
+	if nodeIDs != nil {
+		if len(nodeIDs) < 1 {
+			return []data.Event{}, nil
+		}
+
+		where = append(where, "node_id IN(?"+strings.Repeat(",?", len(nodeIDs)-1)+")")
+		for _, id := range nodeIDs {
+			args = append(args, id)
+		}
+	}
+
+	if q.Level > 0 {
+		where = append(where, "level <= ?")
+		args = append(args, q.Level)
+	}
+
+	if len(q.Types) > 0 {
+		where = append(where, "type IN(?"+strings.Repeat(",?", len(q.Types)-1)+")")
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+
+	if !q.Start.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.Start.UnixNano())
+	}
+
+	if !q.Stop.IsZero() {
+		where = append(where, "time <= ?")
+		args = append(args, q.Stop.UnixNano())
+	}
+
+	query := "SELECT time, node_id, type, level, message FROM events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = eventQueryLimit
+	}
+
+	query += " ORDER BY time DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := sdb.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []data.Event{}
+
+	for rows.Next() {
+		var e data.Event
+		var t int64
+		err := rows.Scan(&t, &e.NodeID, &e.Type, &e.Level, &e.Message)
+		if err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, t)
+		ret = append(ret, e)
+	}
+
+	return ret, rows.Err()
+}
+
+// deleteEventsBefore removes events older than t
+func (sdb *DbSqlite) deleteEventsBefore(t time.Time) error {
+	sdb.writeLock.Lock()
+	defer sdb.writeLock.Unlock()
+
+	_, err := sdb.db.Exec(`DELETE FROM events WHERE time < ?`, t.UnixNano())
+	return err
+}
+
+func (st *Store) handleEvent(msg *nats.Msg) {
+	var e data.Event
+	err := json.Unmarshal(msg.Data, &e)
+	if err != nil {
+		log.Println("Error decoding event:", err)
+		return
+	}
+
+	chunks := strings.Split(msg.Subject, ".")
+	if len(chunks) < 2 {
+		log.Println("Error in event subject:", msg.Subject)
+		return
+	}
+
+	e.NodeID = chunks[1]
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	err = st.db.insertEvent(e)
+	if err != nil {
+		log.Println("Error storing event:", err)
+	}
+}
+
+func (st *Store) handleEventsRequest(msg *nats.Msg) {
+	var q data.EventQuery
+	results := data.EventResults{Events: []data.Event{}}
+
+	err := st.eventsRequest(msg, &q, &results)
+	if err != nil {
+		results.ErrorMessage = err.Error()
+	}
+
+	res, err := json.Marshal(results)
+	if err != nil {
+		res = []byte(`{"error":"error encoding response"}`)
+	}
+
+	err = msg.Respond(res)
+	if err != nil {
+		log.Println("NATS: Error publishing response to events request:", err)
+	}
+}
+
+func (st *Store) eventsRequest(msg *nats.Msg, q *data.EventQuery, results *data.EventResults) error {
+	chunks := strings.Split(msg.Subject, ".")
+	if len(chunks) < 2 {
+		return fmt.Errorf("Error in message subject: %v", msg.Subject)
+	}
+
+	nodeID := chunks[1]
+
+	if len(msg.Data) > 0 {
+		err := json.Unmarshal(msg.Data, q)
+		if err != nil {
+			return fmt.Errorf("parsing query: %w", err)
+		}
+	}
+
+	var nodeIDs []string
+
+	if nodeID != st.db.rootNodeID() {
+		nodes, err := st.db.getDescendants(nil, nodeID, "")
+		if err != nil {
+			return fmt.Errorf("Error getting nodes for %v: %w", nodeID, err)
+		}
+
+		nodeIDs = make([]string, len(nodes))
+		for i, n := range nodes {
+			nodeIDs[i] = n.ID
+		}
+	}
+
+	events, err := st.db.queryEvents(nodeIDs, *q)
+	if err != nil {
+		return fmt.Errorf("Error querying events: %w", err)
+	}
+
+	results.Events = events
+	return nil
+}
+
+// pruneEvents removes events older than the retention period
+func (st *Store) pruneEvents() {
+	retention := st.params.EventRetention
+	if retention <= 0 {
+		retention = defaultEventRetention
+	}
+
+	err := st.db.deleteEventsBefore(time.Now().Add(-retention))
+	if err != nil {
+		log.Println("Error pruning events:", err)
+	}
+}
@@ -0,0 +1,529 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// defaults used if history options are not configured
+const (
+	defaultHistoryRetention    = 90 * 24 * time.Hour
+	defaultHistoryRawRetention = 7 * 24 * time.Hour
+	defaultHistoryRollup       = time.Hour
+)
+
+// historyBackendTypes are node types that answer HistoryQuery requests on
+// history.<id>, in order of preference. Only database (InfluxDB) nodes are
+// supported; there is no file archive backend.
+var historyBackendTypes = []string{data.NodeTypeDb}
+
+// historyRequestTimeout is how long to wait for a history backend
+const historyRequestTimeout = 15 * time.Second
+
+// historyColumns maps history query tag filters to history table columns.
+// Other "node.*" tags are filtered after the query.
+var historyColumns = map[string]string{
+	"node.id":   "node_id",
+	"node.type": "node_type",
+	"type":      "type",
+	"key":       "key",
+}
+
+// historyEnabled returns true if history is recorded for the node type
+func (st *Store) historyEnabled(nodeType string) bool {
+	return st.params.History || slices.Contains(st.params.HistoryNodeTypes, nodeType)
+}
+
+// recordHistory writes points to the local history table if history
+// is enabled for the node
+func (st *Store) recordHistory(nodeID string, points data.Points) {
+	if !st.params.History && len(st.params.HistoryNodeTypes) <= 0 {
+		return
+	}
+
+	nodeType, err := st.db.nodeType(nodeID)
+	if err != nil {
+		log.Printf("Error getting node type for history %v: %v", nodeID, err)
+		return
+	}
+
+	if !st.historyEnabled(nodeType) {
+		return
+	}
+
+	err = st.db.insertHistory(nodeID, nodeType, points)
+	if err != nil {
+		log.Println("Error writing history:", err)
+	}
+}
+
+// pruneHistory downsamples and removes history as configured by
+// the history retention options
+func (st *Store) pruneHistory() {
+	if !st.params.History && len(st.params.HistoryNodeTypes) <= 0 {
+		return
+	}
+
+	retention := st.params.HistoryRetention
+	if retention <= 0 {
+		retention = defaultHistoryRetention
+	}
+
+	raw := st.params.HistoryRawRetention
+	if raw <= 0 {
+		raw = defaultHistoryRawRetention
+	}
+
+	rollup := st.params.HistoryRollup
+	if rollup <= 0 {
+		rollup = defaultHistoryRollup
+	}
+
+	now := time.Now()
+
+	err := st.db.downsampleHistory(now.Add(-raw), rollup)
+	if err != nil {
+		log.Println("Error downsampling history:", err)
+	}
+
+	err = st.db.deleteHistoryBefore(now.Add(-retention))
+	if err != nil {
+		log.Println("Error pruning history:", err)
+	}
+}
+
+func (st *Store) handleHistoryRequest(msg *nats.Msg) {
+	var q data.HistoryQuery
+	results := data.HistoryResults{}
+
+	err := json.Unmarshal(msg.Data, &q)
+	if err != nil {
+		results.ErrorMessage = "parsing query: " + err.Error()
+	} else {
+		err = st.db.queryHistory(q, &results)
+		if err != nil {
+			results.ErrorMessage = err.Error()
+		}
+	}
+
+	res, err := json.Marshal(results)
+	if err != nil {
+		res = []byte(`{"error":"error encoding response"}`)
+	}
+
+	err = msg.Respond(res)
+	if err != nil {
+		log.Println("NATS: Error publishing response to history request:", err)
+	}
+}
+
+func (st *Store) handleNodeHistoryRequest(msg *nats.Msg) {
+	results := data.HistoryResults{}
+
+	err := st.nodeHistoryRequest(msg, &results)
+	if err != nil {
+		results.ErrorMessage = err.Error()
+	}
+
+	res, err := json.Marshal(results)
+	if err != nil {
+		res = []byte(`{"error":"error encoding response"}`)
+	}
+
+	err = msg.Respond(res)
+	if err != nil {
+		log.Println("NATS: Error publishing response to node history request:", err)
+	}
+}
+
+// nodeHistoryRequest answers a history request for a node from the local
+// history if it is enabled for the node, otherwise the request is forwarded
+// to the first history backend node found.
+func (st *Store) nodeHistoryRequest(msg *nats.Msg, results *data.HistoryResults) error {
+	chunks := strings.Split(msg.Subject, ".")
+	if len(chunks) < 2 {
+		return fmt.Errorf("Error in message subject: %v", msg.Subject)
+	}
+
+	nodeID := chunks[1]
+
+	var nq data.NodeHistoryQuery
+	err := json.Unmarshal(msg.Data, &nq)
+	if err != nil {
+		return fmt.Errorf("parsing query: %w", err)
+	}
+
+	q := nq.HistoryQuery(nodeID)
+
+	nodeType, err := st.db.nodeType(nodeID)
+	if err != nil {
+		return fmt.Errorf("Error getting node %v: %w", nodeID, err)
+	}
+
+	if st.historyEnabled(nodeType) {
+		return st.db.queryHistory(q, results)
+	}
+
+	backend, err := st.historyBackend()
+	if err != nil {
+		return err
+	}
+
+	d, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+
+	res, err := st.nc.Request(client.SubjectHistory(backend), d, historyRequestTimeout)
+	if err != nil {
+		return fmt.Errorf("Error requesting history from %v: %w", backend, err)
+	}
+
+	return json.Unmarshal(res.Data, results)
+}
+
+// historyBackend returns the ID of the first history backend node
+func (st *Store) historyBackend() (string, error) {
+	for _, typ := range historyBackendTypes {
+		nodes, err := st.db.getDescendants(nil, st.db.rootNodeID(), typ)
+		if err != nil {
+			return "", err
+		}
+
+		for _, n := range nodes {
+			if disabled, _ := n.Points.Value(data.PointTypeDisabled, ""); disabled == 0 {
+				return n.ID, nil
+			}
+		}
+	}
+
+	return "", errors.New("no history backend configured")
+}
+
+// nodeType returns the type of a node
+func (sdb *DbSqlite) nodeType(id string) (string, error) {
+	var typ string
+	err := sdb.db.QueryRow(`SELECT type FROM edges WHERE down=? LIMIT 1`, id).Scan(&typ)
+	return typ, err
+}
+
+func (sdb *DbSqlite) insertHistory(nodeID, nodeType string, points data.Points) error {
+	sdb.writeLock.Lock()
+	defer sdb.writeLock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO history(node_id, node_type, type, key, time, value, text)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, p := range points {
+		if p.Time.IsZero() {
+			p.Time = time.Now()
+		}
+
+		if p.Key == "" {
+			p.Key = "0"
+		}
+
+		_, err := stmt.Exec(nodeID, nodeType, p.Type, p.Key, p.Time.UnixNano(), p.Value, p.Text)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// downsampleHistory rolls up history points older than cutoff into min/max/mean
+// windows of the rollup duration and removes the raw points. Text points can
+// not be rolled up, so they are kept at full resolution until they are removed
+// by deleteHistoryBefore.
+func (sdb *DbSqlite) downsampleHistory(cutoff time.Time, rollup time.Duration) error {
+	cutoff = cutoff.Truncate(rollup)
+	w := rollup.Nanoseconds()
+
+	sdb.writeLock.Lock()
+	defer sdb.writeLock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO history_rollup(node_id, node_type, type, key, time,
+		duration, min, max, sum, count)
+		SELECT node_id, node_type, type, key, (time / ?1) * ?1, ?1,
+			MIN(value), MAX(value), SUM(value), COUNT(*)
+		FROM history WHERE time < ?2 AND text = ''
+		GROUP BY node_id, node_type, type, key, time / ?1
+		ON CONFLICT(node_id, type, key, time) DO UPDATE SET
+		min = min(min, excluded.min),
+		max = max(max, excluded.max),
+		sum = sum + excluded.sum,
+		count = count + excluded.count`, w, cutoff.UnixNano())
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM history WHERE time < ? AND text = ''`, cutoff.UnixNano())
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteHistoryBefore removes all history older than t
+func (sdb *DbSqlite) deleteHistoryBefore(t time.Time) error {
+	sdb.writeLock.Lock()
+	defer sdb.writeLock.Unlock()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"history", "history_rollup"} {
+		_, err := tx.Exec(`DELETE FROM `+table+` WHERE time < ?`, t.UnixNano())
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// queryHistory executes a history query against the local history tables.
+// Raw points that have been downsampled are returned as their rollup mean.
+func (sdb *DbSqlite) queryHistory(q data.HistoryQuery, results *data.HistoryResults) error {
+	if q.Stop.IsZero() {
+		q.Stop = time.Now()
+	}
+
+	where := []string{"time >= ?", "time < ?"}
+	args := []any{q.Start.UnixNano(), q.Stop.UnixNano()}
+
+	// tags that are not columns are filtered after the query
+	tagFilters := data.TagFilters{}
+
+	for k, v := range q.TagFilters {
+		col, ok := historyColumns[k]
+		if !ok {
+			if !strings.HasPrefix(k, "node.") {
+				return fmt.Errorf("invalid tag filter '%v'", k)
+			}
+			if _, err := tagFilterValues(k, v); err != nil {
+				return err
+			}
+			tagFilters[k] = v
+			continue
+		}
+
+		values, err := tagFilterValues(k, v)
+		if err != nil {
+			return err
+		}
+
+		if len(values) <= 0 {
+			continue
+		}
+
+		where = append(where, col+" IN(?"+strings.Repeat(",?", len(values)-1)+")")
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+
+	whereS := strings.Join(where, " AND ")
+
+	var query string
+	if q.AggregateWindow == nil {
+		query = `SELECT node_id, node_type, type, key, time, value, text FROM history
+			WHERE ` + whereS + `
+			UNION ALL
+			SELECT node_id, node_type, type, key, time, sum / count, '' FROM history_rollup
+			WHERE ` + whereS + `
+			ORDER BY time`
+		args = append(args, args...)
+	} else {
+		w := q.AggregateWindow.Nanoseconds()
+		if w <= 0 {
+			return errors.New("aggregate window must be greater than 0")
+		}
+
+		query = `SELECT node_id, node_type, type, key, time / ? AS win,
+				MIN(min), MAX(max), SUM(sum), SUM(count) FROM (
+				SELECT node_id, node_type, type, key, time,
+					value AS min, value AS max, value AS sum, 1 AS count
+				FROM history WHERE ` + whereS + ` AND text = ''
+				UNION ALL
+				SELECT node_id, node_type, type, key, time, min, max, sum, count
+				FROM history_rollup WHERE ` + whereS + `)
+			GROUP BY node_id, node_type, type, key, win
+			ORDER BY win`
+		args = append(append([]any{w}, args...), args...)
+	}
+
+	rows, err := sdb.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("executing query: %w", err)
+	}
+	defer rows.Close()
+
+	// cache node tags as many points will be for the same nodes
+	nodeTags := make(map[string]map[string]string)
+
+	getTags := func(nodeID, nodeType string) (map[string]string, error) {
+		tags, ok := nodeTags[nodeID]
+		if ok {
+			return tags, nil
+		}
+
+		var desc string
+		err := sdb.db.QueryRow(`SELECT text FROM node_points
+			WHERE node_id=? AND type=? AND tombstone%2=0 LIMIT 1`,
+			nodeID, data.PointTypeDescription).Scan(&desc)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		tags = map[string]string{
+			"node.id":          nodeID,
+			"node.type":        nodeType,
+			"node.description": desc,
+		}
+		nodeTags[nodeID] = tags
+		return tags, nil
+	}
+
+	for rows.Next() {
+		var nodeID, nodeType, typ, key string
+
+		if q.AggregateWindow == nil {
+			var t int64
+			var value float64
+			var text string
+			err := rows.Scan(&nodeID, &nodeType, &typ, &key, &t, &value, &text)
+			if err != nil {
+				return fmt.Errorf("decoding history: %w", err)
+			}
+
+			tags, err := getTags(nodeID, nodeType)
+			if err != nil {
+				return fmt.Errorf("getting node tags: %w", err)
+			}
+
+			if !tagsMatch(tagFilters, tags) {
+				continue
+			}
+
+			results.Points = append(results.Points, data.HistoryPoint{
+				Time:     time.Unix(0, t),
+				NodeTags: tags,
+				Type:     typ,
+				Key:      key,
+				Value:    value,
+				Text:     text,
+			})
+		} else {
+			var win int64
+			var minV, maxV, sum float64
+			var count int64
+			err := rows.Scan(&nodeID, &nodeType, &typ, &key, &win, &minV, &maxV, &sum, &count)
+			if err != nil {
+				return fmt.Errorf("decoding history: %w", err)
+			}
+
+			tags, err := getTags(nodeID, nodeType)
+			if err != nil {
+				return fmt.Errorf("getting node tags: %w", err)
+			}
+
+			if !tagsMatch(tagFilters, tags) {
+				continue
+			}
+
+			// like Influx, aggregated points are timestamped with the
+			// end of the window
+			t := time.Unix(0, (win+1)*q.AggregateWindow.Nanoseconds())
+			if t.After(q.Stop) {
+				t = q.Stop
+			}
+
+			results.AggregatedPoints = append(results.AggregatedPoints,
+				data.HistoryAggregatedPoint{
+					Time:     t,
+					NodeTags: tags,
+					Type:     typ,
+					Key:      key,
+					Mean:     sum / float64(count),
+					Min:      minV,
+					Max:      maxV,
+					Count:    count,
+				})
+		}
+	}
+
+	return rows.Err()
+}
+
+// tagFilterValues returns the values of a tag filter as a slice of strings
+func tagFilterValues(k string, v any) ([]string, error) {
+	switch typedV := v.(type) {
+	case string:
+		return []string{typedV}, nil
+	case []string:
+		return typedV, nil
+	case []any:
+		ret := make([]string, len(typedV))
+		for i, elemV := range typedV {
+			s, ok := elemV.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid tag filter value for %v[%v]", k, i)
+			}
+			ret[i] = s
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("invalid tag filter value for '%v': invalid type", k)
+	}
+}
+
+// tagsMatch returns true if tags match all the filters. A filter value of ""
+// matches a missing or empty tag.
+func tagsMatch(filters data.TagFilters, tags map[string]string) bool {
+	for k, v := range filters {
+		values, _ := tagFilterValues(k, v)
+		if len(values) <= 0 {
+			continue
+		}
+
+		if !slices.Contains(values, tags[k]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -120,6 +120,70 @@ func NewSqliteDb(dbFile string, rootID string) (*DbSqlite, error) {
 		return nil, fmt.Errorf("Error creating edge_points table: %v", err)
 	}
 
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS events (id INTEGER PRIMARY KEY AUTOINCREMENT,
+				time INT,
+				node_id TEXT,
+				type INT,
+				level INT,
+				message TEXT)`)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error creating events table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS eventTime ON events(time)`)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS history (node_id TEXT,
+				node_type TEXT,
+				type TEXT,
+				key TEXT,
+				time INT,
+				value REAL,
+				text TEXT)`)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error creating history table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS historyTime ON history(time)`)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS historyNode ON history(node_id, type, key, time)`)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS history_rollup (node_id TEXT,
+				node_type TEXT,
+				type TEXT,
+				key TEXT,
+				time INT,
+				duration INT,
+				min REAL,
+				max REAL,
+				sum REAL,
+				count INT,
+				UNIQUE(node_id, type, key, time))`)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error creating history_rollup table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS historyRollupTime ON history_rollup(time)`)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS historyRollupNode ON history_rollup(node_id, type, key, time)`)
+	if err != nil {
+		return nil, err
+	}
+
 	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS edgeUp ON edges(up)`)
 	if err != nil {
 		return nil, err
@@ -228,7 +292,8 @@ func (sdb *DbSqlite) reset() error {
 	var err error
 
 	// truncate several tables
-	tables := []string{"meta", "edges", "node_points", "edge_points"}
+	tables := []string{"meta", "edges", "node_points", "edge_points", "events",
+		"history", "history_rollup"}
 	for _, v := range tables {
 		_, err = sdb.db.Exec(`DELETE FROM ` + v)
 		if err != nil {
@@ -1020,6 +1085,53 @@ func (sdb *DbSqlite) getNodes(tx *sql.Tx, parent, id, typ string, includeDel boo
 	return ret, nil
 }
 
+// getDescendants returns the node with id and all nodes below it in the
+// tree that are of type typ. If typ is blank, all nodes are returned.
+// Deleted nodes are not included.
+func (sdb *DbSqlite) getDescendants(tx *sql.Tx, id, typ string) ([]data.NodeEdge, error) {
+	var ret []data.NodeEdge
+	visited := make(map[string]bool)
+
+	var helper func(ne data.NodeEdge) error
+	helper = func(ne data.NodeEdge) error {
+		if visited[ne.ID] {
+			// mirrored nodes can show up more than once
+			return nil
+		}
+		visited[ne.ID] = true
+
+		if typ == "" || ne.Type == typ {
+			ret = append(ret, ne)
+		}
+
+		children, err := sdb.getNodes(tx, ne.ID, "all", "", false)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range children {
+			err := helper(c)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	nodes, err := sdb.getNodes(tx, "all", id, "", false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) < 1 {
+		return nil, data.ErrDocumentNotFound
+	}
+
+	err = helper(nodes[0])
+	return ret, err
+}
+
 // returns points, and error
 func (sdb *DbSqlite) queryPoints(tx *sql.Tx, query string, args ...any) (map[string]data.Points, error) {
 	retPoints := make(map[string]data.Points)
@@ -340,3 +340,142 @@ func TestDbSqliteBatchPoints(t *testing.T) {
 	}
 
 }
+
+func TestDbSqliteHistory(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	rootID := db.rootNodeID()
+
+	err := db.nodePoints(rootID, data.Points{{Type: data.PointTypeDescription, Text: "root"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// two hours of data, one point every 10 minutes
+	var pts data.Points
+	for i := 0; i < 12; i++ {
+		pts = append(pts, data.Point{Time: start.Add(time.Duration(i) * 10 * time.Minute),
+			Type: data.PointTypeValue, Value: float64(i)})
+	}
+
+	pts = append(pts, data.Point{Time: start, Type: data.PointTypeTemperature, Value: 100},
+		data.Point{Time: start.Add(10 * time.Minute), Type: data.PointTypeDescription,
+			Text: "door open"})
+
+	err = db.insertHistory(rootID, data.NodeTypeDevice, pts)
+	if err != nil {
+		t.Fatal("Error inserting history: ", err)
+	}
+
+	stop := start.Add(2 * time.Hour)
+	hour := time.Hour
+
+	query := func(q data.HistoryQuery) data.HistoryResults {
+		t.Helper()
+		var res data.HistoryResults
+		err := db.queryHistory(q, &res)
+		if err != nil {
+			t.Fatal("Error querying history: ", err)
+		}
+		return res
+	}
+
+	res := query(data.HistoryQuery{Start: start, Stop: stop,
+		TagFilters: data.TagFilters{"type": data.PointTypeValue}})
+
+	if len(res.Points) != 12 {
+		t.Fatal("expected 12 points, got: ", len(res.Points))
+	}
+
+	if res.Points[11].Value != 11 || res.Points[0].NodeTags["node.description"] != "root" {
+		t.Errorf("wrong point: %+v", res.Points[0])
+	}
+
+	res = query(data.HistoryQuery{Start: start, Stop: stop, AggregateWindow: &hour,
+		TagFilters: data.TagFilters{"type": data.PointTypeValue}})
+
+	checkAgg := func(res data.HistoryResults) {
+		t.Helper()
+		if len(res.AggregatedPoints) != 2 {
+			t.Fatalf("expected 2 aggregated points, got: %+v", res.AggregatedPoints)
+		}
+
+		a := res.AggregatedPoints[1]
+		if a.Min != 6 || a.Max != 11 || a.Mean != 8.5 || a.Count != 6 ||
+			!a.Time.Equal(stop) {
+			t.Errorf("wrong aggregated point: %+v", a)
+		}
+	}
+
+	checkAgg(res)
+
+	res = query(data.HistoryQuery{Start: start, Stop: stop,
+		TagFilters: data.TagFilters{"node.description": "other"}})
+
+	if len(res.Points) != 0 {
+		t.Error("description filter failed, got: ", len(res.Points))
+	}
+
+	// downsample the first hour
+	err = db.downsampleHistory(start.Add(time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal("Error downsampling history: ", err)
+	}
+
+	res = query(data.HistoryQuery{Start: start, Stop: stop,
+		TagFilters: data.TagFilters{"type": data.PointTypeValue}})
+
+	// first hour is now a single rollup point
+	if len(res.Points) != 7 {
+		t.Fatal("expected 7 points after downsampling, got: ", len(res.Points))
+	}
+
+	if res.Points[0].Value != 2.5 {
+		t.Error("expected rollup mean, got: ", res.Points[0].Value)
+	}
+
+	// aggregation is not changed by downsampling
+	res = query(data.HistoryQuery{Start: start, Stop: stop, AggregateWindow: &hour,
+		TagFilters: data.TagFilters{"type": data.PointTypeValue}})
+
+	checkAgg(res)
+
+	if a := res.AggregatedPoints[0]; a.Min != 0 || a.Max != 5 || a.Count != 6 {
+		t.Errorf("wrong aggregated rollup point: %+v", a)
+	}
+
+	// text points are not rolled up and are kept until retention
+	textFilter := data.TagFilters{"type": data.PointTypeDescription}
+	res = query(data.HistoryQuery{Start: start, Stop: stop, TagFilters: textFilter})
+
+	if len(res.Points) != 1 || res.Points[0].Text != "door open" {
+		t.Errorf("text point lost by downsampling: %+v", res.Points)
+	}
+
+	err = db.deleteHistoryBefore(start.Add(time.Hour))
+	if err != nil {
+		t.Fatal("Error deleting history: ", err)
+	}
+
+	res = query(data.HistoryQuery{Start: start, Stop: stop})
+
+	if len(res.Points) != 6 {
+		t.Error("expected 6 points after retention, got: ", len(res.Points))
+	}
+
+	res = query(data.HistoryQuery{Start: start, Stop: stop, TagFilters: textFilter})
+
+	if len(res.Points) != 0 {
+		t.Error("expected text point to be removed by retention, got: ", res.Points)
+	}
+
+	var res2 data.HistoryResults
+	err = db.queryHistory(data.HistoryQuery{Start: start, Stop: stop,
+		TagFilters: data.TagFilters{"bogus": "x"}}, &res2)
+	if err == nil {
+		t.Error("expected error for invalid tag filter")
+	}
+}
@@ -50,6 +50,20 @@ type Params struct {
 	// ID for the instance -- it is only used when initializing the store.
 	// ID must be unique. If ID is not set, then a UUID is generated.
 	ID string
+	// EventRetention is how long events are kept. Defaults to 30 days.
+	EventRetention time.Duration
+	// History enables recording all points in the local history table
+	History bool
+	// HistoryNodeTypes enables history for these node types only
+	HistoryNodeTypes []string
+	// HistoryRetention is how long history is kept. Defaults to 90 days.
+	HistoryRetention time.Duration
+	// HistoryRawRetention is how long points are kept at full resolution
+	// before they are downsampled. Defaults to 7 days.
+	HistoryRawRetention time.Duration
+	// HistoryRollup is the window points are downsampled into. Defaults
+	// to 1 hour.
+	HistoryRollup time.Duration
 }
 
 // NewStore creates a new NATS client for handling SIOT requests
@@ -107,19 +121,35 @@ func (st *Store) Run() error {
 		return fmt.Errorf("Subscribe edge points error: %w", err)
 	}
 
+	st.subscriptions["pointBatch"], err = nc.Subscribe(client.SubjectPointBatch(), st.handlePointBatch)
+	if err != nil {
+		return fmt.Errorf("Subscribe point batch error: %w", err)
+	}
+
 	if st.subscriptions["nodes"], err = nc.Subscribe("nodes.*.*", st.handleNodesRequest); err != nil {
 		return fmt.Errorf("Subscribe node error: %w", err)
 	}
 
-	/*
-		if st.subscriptions["notifications"], err = nc.Subscribe("node.*.not", st.handleNotification); err != nil {
-			return fmt.Errorf("Subscribe notification error: %w", err)
-		}
+	if st.subscriptions["alarms"], err = nc.Subscribe("alarms.*", st.handleAlarmsRequest); err != nil {
+		return fmt.Errorf("Subscribe alarms error: %w", err)
+	}
 
-		if st.subscriptions["messages"], err = nc.Subscribe("node.*.msg", st.handleMessage); err != nil {
-			return fmt.Errorf("Subscribe message error: %w", err)
-		}
-	*/
+	if st.subscriptions["event"], err = nc.Subscribe("event.*", st.handleEvent); err != nil {
+		return fmt.Errorf("Subscribe event error: %w", err)
+	}
+
+	if st.subscriptions["events"], err = nc.Subscribe("events.*", st.handleEventsRequest); err != nil {
+		return fmt.Errorf("Subscribe events error: %w", err)
+	}
+
+	subjectHistory := client.SubjectHistory(st.db.rootNodeID())
+	if st.subscriptions["history"], err = nc.Subscribe(subjectHistory, st.handleHistoryRequest); err != nil {
+		return fmt.Errorf("Subscribe history error: %w", err)
+	}
+
+	if st.subscriptions["nodeHistory"], err = nc.Subscribe("nodeHistory.*", st.handleNodeHistoryRequest); err != nil {
+		return fmt.Errorf("Subscribe node history error: %w", err)
+	}
 
 	if st.subscriptions["auth.user"], err = nc.Subscribe("auth.user", st.handleAuthUser); err != nil {
 		return fmt.Errorf("Subscribe auth error: %w", err)
@@ -137,9 +167,17 @@ func (st *Store) Run() error {
 		return fmt.Errorf("Subscribe dbMaint error: %w", err)
 	}
 
+	st.pruneEvents()
+	st.pruneHistory()
+	pruneTicker := time.NewTicker(time.Hour)
+	defer pruneTicker.Stop()
+
 done:
 	for {
 		select {
+		case <-pruneTicker.C:
+			st.pruneEvents()
+			st.pruneHistory()
 		case <-st.chWaitStart:
 			// don't need to do anything as simply reading this
 			// channel will unblock the caller
@@ -271,6 +309,8 @@ func (st *Store) handleNodePoints(msg *nats.Msg) {
 		return
 	}
 
+	st.recordHistory(nodeID, points)
+
 	// process point in upstream nodes
 	err = st.processPointsUpstream(nodeID, nodeID, points)
 	if err != nil {
@@ -321,6 +361,34 @@ func (st *Store) handleEdgePoints(msg *nats.Msg) {
 	st.reply(msg.Reply, nil)
 }
 
+// handlePointBatch publishes each message in a batch on its node or edge
+// points subject so the batch is processed the same as individual messages.
+// An error is only returned if the batch can't be decoded.
+func (st *Store) handlePointBatch(msg *nats.Msg) {
+	msgs, err := client.DecodePointBatch(msg.Data)
+	if err != nil {
+		log.Println("Error decoding point batch:", err)
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	// messages that fail are logged, but not returned as the sender would
+	// drop the whole batch, including the messages that were applied
+	for _, m := range msgs {
+		if m.Parent == "" {
+			err = client.SendNodePoints(st.nc, m.ID, m.Points, true)
+		} else {
+			err = client.SendEdgePoints(st.nc, m.ID, m.Parent, m.EdgePoints, true)
+		}
+
+		if err != nil {
+			log.Printf("Error processing batch points for %v: %v\n", m.ID, err)
+		}
+	}
+
+	st.reply(msg.Reply, nil)
+}
+
 func (st *Store) handleNodesRequest(msg *nats.Msg) {
 	start := time.Now()
 	defer func() {
@@ -393,6 +461,39 @@ handleNodeDone:
 	}
 }
 
+// handleAlarmsRequest returns all alarm nodes at or below a node
+func (st *Store) handleAlarmsRequest(msg *nats.Msg) {
+	resp := &pb.NodesRequest{}
+	var nodes data.Nodes
+	var err error
+
+	chunks := strings.Split(msg.Subject, ".")
+	if len(chunks) < 2 {
+		resp.Error = fmt.Sprintf("Error in message subject: %v", msg.Subject)
+	} else {
+		nodes, err = st.db.getDescendants(nil, chunks[1], data.NodeTypeAlarm)
+		if err != nil {
+			resp.Error = fmt.Sprintf("Error getting alarms for %v: %v", chunks[1], err)
+		}
+	}
+
+	resp.Nodes, err = nodes.ToPbNodes()
+	if err != nil {
+		resp.Error = fmt.Sprintf("Error pb encoding node: %v\n", err)
+	}
+
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		log.Println("marshal error:", err)
+		return
+	}
+
+	err = st.nc.Publish(msg.Reply, data)
+	if err != nil {
+		log.Println("NATS: Error publishing response to alarms request:", err)
+	}
+}
+
 // TODO, maybe someday we should return error node instead of no data
 func (st *Store) handleAuthUser(msg *nats.Msg) {
 	var points data.Points
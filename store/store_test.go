@@ -1,6 +1,7 @@
 package store_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
@@ -188,3 +189,274 @@ func TestDontAllowDeleteRootNode(t *testing.T) {
 		t.Fatal("Root node was deleted")
 	}
 }
+
+func TestStorePointBatch(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	v := client.Variable{ID: "var-batch", Parent: root.ID, Description: "batch"}
+	err = client.SendNodeType(nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending variable: ", err)
+	}
+
+	// deleting the root node fails, but the rest of the batch should be
+	// applied and no error returned
+	batch, err := client.EncodePointBatch(data.Nodes{
+		{ID: root.ID, Parent: "root", EdgePoints: data.Points{
+			{Time: time.Now(), Type: data.PointTypeTombstone, Value: 1}}},
+		{ID: v.ID, Points: data.Points{
+			{Time: time.Now(), Type: data.PointTypeValue, Value: 12}}},
+	}, false)
+	if err != nil {
+		t.Fatal("Error encoding batch: ", err)
+	}
+
+	res, err := nc.Request(client.SubjectPointBatch(), batch, time.Second)
+	if err != nil {
+		t.Fatal("Error sending batch: ", err)
+	}
+
+	if len(res.Data) > 0 {
+		t.Error("batch returned error: ", string(res.Data))
+	}
+
+	nodes, err := client.GetNodes(nc, root.ID, v.ID, "", false)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting variable: ", err)
+	}
+
+	if val, _ := nodes[0].Points.Value(data.PointTypeValue, ""); val != 12 {
+		t.Error("batch points not applied: ", val)
+	}
+
+	res, err = nc.Request(client.SubjectPointBatch(), []byte{5, 1, 2}, time.Second)
+	if err != nil {
+		t.Fatal("Error sending batch: ", err)
+	}
+
+	if len(res.Data) <= 0 {
+		t.Error("expected error for invalid batch")
+	}
+}
+
+func TestStoreEvents(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	group := data.NodeEdge{ID: "ID-group", Type: data.NodeTypeGroup, Parent: root.ID}
+	dev := data.NodeEdge{ID: "ID-dev", Type: data.NodeTypeDevice, Parent: group.ID}
+	other := data.NodeEdge{ID: "ID-other", Type: data.NodeTypeDevice, Parent: root.ID}
+
+	for _, n := range []data.NodeEdge{group, dev, other} {
+		err := client.SendNode(nc, n, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	now := time.Now()
+
+	events := []data.Event{
+		{NodeID: group.ID, Time: now.Add(-3 * time.Minute), Type: data.EventTypeRuleActive,
+			Level: data.EventLevelInfo, Message: "group info"},
+		{NodeID: dev.ID, Time: now.Add(-2 * time.Minute), Type: data.EventTypeSyncDisconnect,
+			Level: data.EventLevelFault, Message: "dev fault"},
+		{NodeID: dev.ID, Time: now.Add(-time.Minute), Type: data.EventTypeSyncConnect,
+			Level: data.EventLevelDebug, Message: "dev debug"},
+		{NodeID: other.ID, Time: now, Type: data.EventTypeRuleActive,
+			Level: data.EventLevelFault, Message: "other fault"},
+	}
+
+	for _, e := range events {
+		err := client.SendEvent(nc, e.NodeID, e)
+		if err != nil {
+			t.Fatal("Error sending event: ", err)
+		}
+	}
+
+	getEvents := func(id string, q data.EventQuery, count int) []data.Event {
+		t.Helper()
+		start := time.Now()
+		for {
+			ret, err := client.GetEvents(nc, id, q)
+			if err != nil {
+				t.Fatal("Error getting events: ", err)
+			}
+			if len(ret) == count {
+				return ret
+			}
+			if time.Since(start) > time.Second {
+				t.Fatalf("expected %v events, got %+v", count, ret)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// subtree query, newest first
+	ret := getEvents(group.ID, data.EventQuery{}, 3)
+	if ret[0].Message != "dev debug" || ret[2].Message != "group info" {
+		t.Errorf("events not in correct order: %+v", ret)
+	}
+
+	ret = getEvents(group.ID, data.EventQuery{Level: data.EventLevelFault}, 1)
+	if ret[0].Message != "dev fault" {
+		t.Errorf("wrong event for level filter: %+v", ret)
+	}
+
+	ret = getEvents(root.ID, data.EventQuery{Types: []data.EventType{data.EventTypeRuleActive}}, 2)
+	if ret[0].Message != "other fault" {
+		t.Errorf("wrong event for type filter: %+v", ret)
+	}
+
+	ret = getEvents(group.ID, data.EventQuery{
+		Start: now.Add(-150 * time.Second), Stop: now.Add(-30 * time.Second)}, 2)
+	if ret[1].Message != "dev fault" {
+		t.Errorf("wrong event for time filter: %+v", ret)
+	}
+
+	getEvents(group.ID, data.EventQuery{Limit: 1}, 1)
+}
+
+func TestStoreHistory(t *testing.T) {
+	server.TestServerOptions.HistoryNodeTypes = []string{data.NodeTypeVariable}
+	defer func() {
+		server.TestServerOptions.HistoryNodeTypes = nil
+	}()
+
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	v := data.NodeEdge{ID: "ID-var", Type: data.NodeTypeVariable, Parent: root.ID}
+
+	err = client.SendNode(nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		err := client.SendNodePoint(nc, v.ID, data.Point{Type: data.PointTypeValue,
+			Value: float64(i)}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	// root node points are not recorded
+	err = client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeValue,
+		Value: 10}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	q, err := json.Marshal(data.HistoryQuery{Start: start, Stop: time.Now(),
+		TagFilters: data.TagFilters{"type": data.PointTypeValue}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := nc.Request(client.SubjectHistory(root.ID), q, time.Second)
+	if err != nil {
+		t.Fatal("Error requesting history: ", err)
+	}
+
+	var res data.HistoryResults
+	err = json.Unmarshal(msg.Data, &res)
+	if err != nil {
+		t.Fatal("Error decoding history: ", err)
+	}
+
+	if res.ErrorMessage != "" {
+		t.Fatal("History error: ", res.ErrorMessage)
+	}
+
+	if len(res.Points) != 3 {
+		t.Fatalf("expected 3 history points, got: %+v", res.Points)
+	}
+
+	if res.Points[2].Value != 2 || res.Points[2].NodeTags["node.id"] != v.ID {
+		t.Errorf("wrong history point: %+v", res.Points[2])
+	}
+}
+
+func TestStoreNodeHistory(t *testing.T) {
+	server.TestServerOptions.HistoryNodeTypes = []string{data.NodeTypeVariable}
+	defer func() {
+		server.TestServerOptions.HistoryNodeTypes = nil
+	}()
+
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	v := data.NodeEdge{ID: "ID-var", Type: data.NodeTypeVariable, Parent: root.ID}
+
+	err = client.SendNode(nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	start := time.Now()
+
+	pts := data.Points{
+		{Type: data.PointTypeValue, Key: "0", Value: 1},
+		{Type: data.PointTypeValue, Key: "1", Value: 2},
+		{Type: data.PointTypeDescription, Text: "tank"},
+	}
+
+	err = client.SendNodePoints(nc, v.ID, pts, true)
+	if err != nil {
+		t.Fatal("Error sending points: ", err)
+	}
+
+	window := time.Minute
+
+	res, err := client.GetHistory(nc, v.ID, data.NodeHistoryQuery{
+		Type: data.PointTypeValue, Key: "1", Start: start, Stop: time.Now(),
+		AggregateWindow: &window})
+	if err != nil {
+		t.Fatal("Error getting history: ", err)
+	}
+
+	if len(res.AggregatedPoints) != 1 || res.AggregatedPoints[0].Max != 2 {
+		t.Fatalf("wrong history: %+v", res.AggregatedPoints)
+	}
+
+	res, err = client.GetHistory(nc, v.ID, data.NodeHistoryQuery{
+		Start: start, Stop: time.Now()})
+	if err != nil {
+		t.Fatal("Error getting history: ", err)
+	}
+
+	if len(res.Points) != 3 {
+		t.Fatalf("expected 3 points, got: %+v", res.Points)
+	}
+
+	// history is not enabled for the root node and there is no db node
+	_, err = client.GetHistory(nc, root.ID, data.NodeHistoryQuery{
+		Start: start, Stop: time.Now()})
+	if err == nil {
+		t.Fatal("expected error for node without history backend")
+	}
+}